@@ -0,0 +1,146 @@
+package archive
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
+	"slices"
+	"time"
+)
+
+const (
+	// Format names the archives written by this package in their header
+	Format = "movie-streaming-archive"
+	// Version of the archive layout, bumped when it changes in a way older builds can't read
+	Version = 1
+)
+
+// Collection is a collection archives carry. Key identifies a document across databases, restoring matches on it
+// rather than on _id so an archive can be restored into a database that already has some of the documents.
+type Collection struct {
+	Name    string
+	Key     []string
+	Secrets []string // fields left out when exporting without passwords
+}
+
+// Collections lists what archives carry, in the order they are written and restored. New collections holding data
+// worth keeping are added here; jobs, security events and movie similarities are left out as they are transient,
+// audit logs or recomputed.
+var Collections = []Collection{
+	{Name: "movies", Key: []string{"imdb_id"}},
+	{Name: "users", Key: []string{"email"}, Secrets: []string{"password"}},
+	{Name: "reviews", Key: []string{"imdb_id", "user_email"}},
+	{Name: "watch_history", Key: []string{"user_email", "imdb_id"}},
+	{Name: "watchlist", Key: []string{"user_email", "imdb_id"}},
+}
+
+// Header is the first line of an archive
+type Header struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	SchemaVersion int       `json:"schema_version"` // latest migration applied to the exported database
+	CreatedAt     time.Time `json:"created_at"`
+	Collections   []string  `json:"collections"`
+	Redacted      []string  `json:"redacted,omitempty"` // "collection.field" left out of the documents
+}
+
+// line is any line after the header: a document in MongoDB extended JSON, or the end marker, which tells a
+// complete archive from a truncated one
+type line struct {
+	Collection string          `json:"collection,omitempty"`
+	Document   json.RawMessage `json:"document,omitempty"`
+	End        map[string]int  `json:"end,omitempty"` // documents written per collection
+}
+
+type ExportOptions struct {
+	SchemaVersion    int
+	Collections      []string // all of Collections when empty
+	WithoutPasswords bool
+}
+
+// Export streams the collections of db to w, one document per line, and returns how many documents each held
+func Export(ctx context.Context, db *mongo.Database, w io.Writer, opts ExportOptions) (map[string]int, error) {
+	collections, err := selectCollections(opts.Collections)
+	if err != nil {
+		return nil, err
+	}
+
+	header := Header{Format: Format, Version: Version, SchemaVersion: opts.SchemaVersion, CreatedAt: time.Now().UTC()}
+	for _, c := range collections {
+		header.Collections = append(header.Collections, c.Name)
+		if opts.WithoutPasswords {
+			for _, field := range c.Secrets {
+				header.Redacted = append(header.Redacted, c.Name+"."+field)
+			}
+		}
+	}
+
+	buf := bufio.NewWriter(w)
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for _, c := range collections {
+		var secrets []string
+		if opts.WithoutPasswords {
+			secrets = c.Secrets
+		}
+		n, err := exportCollection(ctx, db.Collection(c.Name), enc, c.Name, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("exporting %s: %w", c.Name, err)
+		}
+		counts[c.Name] = n
+	}
+
+	if err := enc.Encode(line{End: counts}); err != nil {
+		return nil, err
+	}
+	return counts, buf.Flush()
+}
+
+func exportCollection(ctx context.Context, collection *mongo.Collection, enc *json.Encoder, name string, secrets []string) (int, error) {
+	cursor, err := collection.Find(ctx, bson.D{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	n := 0
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return n, err
+		}
+		doc = slices.DeleteFunc(doc, func(e bson.E) bool { return slices.Contains(secrets, e.Key) })
+		ext, err := bson.MarshalExtJSON(doc, true, false)
+		if err != nil {
+			return n, err
+		}
+		if err := enc.Encode(line{Collection: name, Document: ext}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, cursor.Err()
+}
+
+// selectCollections picks the named collections out of Collections, keeping their order
+func selectCollections(names []string) ([]Collection, error) {
+	if len(names) == 0 {
+		return Collections, nil
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(Collections, func(c Collection) bool { return c.Name == name }) {
+			return nil, fmt.Errorf("collection %q isn't archived", name)
+		}
+	}
+	return slices.DeleteFunc(slices.Clone(Collections), func(c Collection) bool { return !slices.Contains(names, c.Name) }), nil
+}
@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
+	"slices"
+	"strings"
+)
+
+var ErrTruncated = errors.New("the archive ends before its end marker, it is truncated")
+
+// RestoreResult counts what restoring did to the documents of a collection
+type RestoreResult struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+type RestoreOptions struct {
+	SchemaVersion int      // latest migration applied to the database, the archive must have been exported at it
+	Collections   []string // every collection of the archive when empty
+}
+
+// Restore writes the documents of an archive into db. Documents are matched on the key of their collection and
+// replaced, or inserted with their _id when missing, so restoring an archive again changes nothing. Nothing is
+// deleted. Redacted fields keep the value they have in db, users restored without one have to reset their password.
+//
+// open is called twice: the whole archive is checked (header, every line and the counts of its end marker) before
+// anything is written, so a truncated or corrupt archive leaves db untouched.
+func Restore(ctx context.Context, db *mongo.Database, open func() (io.ReadCloser, error), opts RestoreOptions) (map[string]*RestoreResult, error) {
+	if err := readArchive(open, opts, func(Collection, bson.D, []string) error { return nil }); err != nil {
+		return nil, err
+	}
+
+	results := map[string]*RestoreResult{}
+	err := readArchive(open, opts, func(c Collection, doc bson.D, redacted []string) error {
+		result, ok := results[c.Name]
+		if !ok {
+			result = &RestoreResult{}
+			results[c.Name] = result
+		}
+		return restoreDocument(ctx, db.Collection(c.Name), c, doc, redacted, result)
+	})
+	return results, err
+}
+
+// readArchive checks an archive against opts and calls restore with every document of the selected collections
+// along with their redacted fields. It fails on the first invalid line, or when the archive doesn't end with an
+// end marker matching its contents.
+func readArchive(open func() (io.ReadCloser, error), opts RestoreOptions, restore func(c Collection, doc bson.D, redacted []string) error) error {
+	f, err := open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	first, err := readLine(reader)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.New("the archive is empty")
+		}
+		return err
+	}
+	var header Header
+	if err := json.Unmarshal(first, &header); err != nil || header.Format != Format {
+		return errors.New("not a movie streaming archive")
+	}
+	if header.Version > Version {
+		return fmt.Errorf("the archive has version %d, this build reads up to version %d", header.Version, Version)
+	}
+	if header.SchemaVersion != opts.SchemaVersion {
+		return fmt.Errorf("the archive was exported at migration %d and the database is at migration %d, restore it with a build at the same migration",
+			header.SchemaVersion, opts.SchemaVersion)
+	}
+
+	names := opts.Collections
+	if len(names) == 0 {
+		names = header.Collections
+	}
+	for _, name := range names {
+		if !slices.Contains(header.Collections, name) {
+			return fmt.Errorf("the archive has no %s collection", name)
+		}
+		if !slices.ContainsFunc(Collections, func(c Collection) bool { return c.Name == name }) {
+			return fmt.Errorf("this build can't restore the %s collection, leave it out with --collections", name)
+		}
+	}
+	collections, err := selectCollections(names)
+	if err != nil {
+		return err
+	}
+	redacted := map[string][]string{}
+	for _, r := range header.Redacted {
+		collection, field, _ := strings.Cut(r, ".")
+		redacted[collection] = append(redacted[collection], field)
+	}
+
+	read := map[string]int{}
+	for n := 2; ; n++ {
+		raw, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			return ErrTruncated
+		}
+		if err != nil {
+			return err
+		}
+		var l line
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+
+		if l.End != nil {
+			for _, name := range header.Collections {
+				if l.End[name] != read[name] {
+					return fmt.Errorf("the archive holds %d %s documents, its end marker says %d", read[name], name, l.End[name])
+				}
+			}
+			if _, err := readLine(reader); !errors.Is(err, io.EOF) {
+				return fmt.Errorf("line %d: the archive goes on after its end marker", n+1)
+			}
+			return nil
+		}
+
+		if !slices.Contains(header.Collections, l.Collection) {
+			return fmt.Errorf("line %d: %q isn't one of the collections of the archive", n, l.Collection)
+		}
+		read[l.Collection]++
+		i := slices.IndexFunc(collections, func(c Collection) bool { return c.Name == l.Collection })
+		if i < 0 {
+			continue
+		}
+		var doc bson.D
+		if err := bson.UnmarshalExtJSON(l.Document, true, &doc); err != nil {
+			return fmt.Errorf("line %d: %w", n, err)
+		}
+		for _, key := range collections[i].Key {
+			if !slices.ContainsFunc(doc, func(e bson.E) bool { return e.Key == key }) {
+				return fmt.Errorf("line %d: %s document has no %s", n, l.Collection, key)
+			}
+		}
+		if err := restore(collections[i], doc, redacted[l.Collection]); err != nil {
+			return fmt.Errorf("line %d: %s: %w", n, l.Collection, err)
+		}
+	}
+}
+
+// readLine returns the next non blank line. Lines have no length limit, a document can be up to 16 MiB of BSON.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	for {
+		raw, err := r.ReadBytes('\n')
+		raw = bytes.TrimSpace(raw)
+		if len(raw) > 0 {
+			return raw, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func restoreDocument(ctx context.Context, collection *mongo.Collection, c Collection, doc bson.D, redacted []string, result *RestoreResult) error {
+	// readArchive checked the key fields are there
+	filter := bson.D{}
+	for _, key := range c.Key {
+		filter = append(filter, doc[slices.IndexFunc(doc, func(e bson.E) bool { return e.Key == key })])
+	}
+
+	if len(redacted) > 0 {
+		projection := bson.D{}
+		for _, field := range redacted {
+			projection = append(projection, bson.E{Key: field, Value: 1})
+		}
+		var existing bson.D
+		err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&existing)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		for _, e := range existing {
+			if slices.Contains(redacted, e.Key) {
+				doc = append(doc, e)
+			}
+		}
+	}
+
+	// the _id of a document found by key stays as it is, it can't be changed
+	replacement := slices.DeleteFunc(slices.Clone(doc), func(e bson.E) bool { return e.Key == "_id" })
+	res, err := collection.ReplaceOne(ctx, filter, replacement)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.ModifiedCount > 0:
+		result.Updated++
+		return nil
+	case res.MatchedCount > 0:
+		result.Unchanged++
+		return nil
+	}
+
+	if _, err := collection.InsertOne(ctx, doc); err != nil {
+		return err
+	}
+	result.Inserted++
+	return nil
+}
@@ -0,0 +1,280 @@
+package catalogue
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Import formats
+const (
+	FormatCSV    = "csv"
+	FormatNDJSON = "ndjson"
+)
+
+// Row outcomes
+const (
+	RowInserted = "inserted"
+	RowUpdated  = "updated"
+	RowRejected = "rejected"
+)
+
+// maxNDJSONLine bounds a single NDJSON record
+const maxNDJSONLine = 1 << 20
+
+// CSVColumns are the columns of a CSV import, in any order, with a header row naming them. Genres are written as
+// "id:name" pairs separated by "|", e.g. "18:Drama|53:Thriller".
+var CSVColumns = []string{"imdb_id", "title", "poster_path", "youtube_id", "genre", "admin_review", "ranking"}
+
+type RowResult struct {
+	Row    int    `json:"row"` // the record number in a CSV file (header excluded), the line number in NDJSON
+	ImdbID string `json:"imdb_id,omitempty"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type Report struct {
+	DryRun   bool        `json:"dry_run"`
+	Inserted int         `json:"inserted"`
+	Updated  int         `json:"updated"`
+	Rejected int         `json:"rejected"`
+	Rows     []RowResult `json:"rows"`
+}
+
+// Importer adds and updates movies in bulk
+type Importer struct {
+	movies   repository.MovieRepository
+	validate *validator.Validate
+}
+
+func NewImporter(movies repository.MovieRepository) *Importer {
+	return &Importer{movies: movies, validate: validator.New()}
+}
+
+// Import reads movies in format from r and upserts them by imdb_id. Rows are validated like new movies and
+// rejected one by one, the error is reserved for unreadable input. Rows before a read error are written already,
+// the report returned along with the error covers them. A dry run validates without writing, rows that would be
+// updated are reported as such.
+func (im *Importer) Import(ctx context.Context, format string, r io.Reader, dryRun bool) (*Report, error) {
+	var next func() (*models.Movie, error)
+	var err error
+	switch format {
+	case FormatCSV:
+		next, err = csvRows(r)
+	case FormatNDJSON:
+		next = ndjsonRows(r)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{DryRun: dryRun, Rows: []RowResult{}}
+	seen := map[string]int{} // imdb_id to the row it first appeared on
+	for row := 1; ; row++ {
+		movie, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var rowErr rowError
+		if errors.As(err, &rowErr) {
+			report.add(RowResult{Row: row, Status: RowRejected, Reason: rowErr.Error()})
+			continue
+		}
+		if err != nil {
+			return report, fmt.Errorf("row %d: %w", row, err)
+		}
+		if movie == nil {
+			// blank NDJSON line
+			continue
+		}
+
+		result := RowResult{Row: row, ImdbID: movie.ImdbID}
+		if first, ok := seen[movie.ImdbID]; ok && movie.ImdbID != "" {
+			result.Status, result.Reason = RowRejected, fmt.Sprintf("imdb_id already imported on row %d", first)
+		} else {
+			seen[movie.ImdbID] = row
+			result.Status, result.Reason = im.upsert(ctx, movie, dryRun)
+		}
+		report.add(result)
+	}
+	return report, ctx.Err()
+}
+
+func (r *Report) add(result RowResult) {
+	switch result.Status {
+	case RowInserted:
+		r.Inserted++
+	case RowUpdated:
+		r.Updated++
+	case RowRejected:
+		r.Rejected++
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+// upsert validates and stores a movie, returning the outcome of its row
+func (im *Importer) upsert(ctx context.Context, movie *models.Movie, dryRun bool) (status, reason string) {
+	// media files, renditions and the community score are never imported
+	movie.Media = nil
+	movie.HLS = nil
+	movie.Community = nil
+	movie.DeletedAt = nil
+	movie.Version = 0
+	if err := im.validate.Struct(movie); err != nil {
+		return RowRejected, err.Error()
+	}
+
+	if dryRun {
+		_, err := im.movies.FindByImdbID(ctx, movie.ImdbID)
+		switch {
+		case err == nil:
+			return RowUpdated, ""
+		case errors.Is(err, repository.ErrNotFound):
+			// a deleted movie would be rejected, only a real run can tell
+			return RowInserted, ""
+		default:
+			return RowRejected, err.Error()
+		}
+	}
+
+	err := im.movies.Insert(ctx, movie)
+	if err == nil {
+		return RowInserted, ""
+	}
+	if !errors.Is(err, repository.ErrDuplicate) {
+		return RowRejected, err.Error()
+	}
+	// the file is the source of truth, whatever changed since is overwritten
+	err = im.movies.Update(ctx, movie, repository.AnyVersion)
+	switch {
+	case err == nil:
+		return RowUpdated, ""
+	case errors.Is(err, repository.ErrNotFound):
+		return RowRejected, "the movie with this imdb_id is deleted, restore it first"
+	default:
+		return RowRejected, err.Error()
+	}
+}
+
+// rowError rejects a single row, the rest of the input can still be read
+type rowError struct {
+	err error
+}
+
+func (e rowError) Error() string { return e.err.Error() }
+
+func csvRows(r io.Reader) (func() (*models.Movie, error), error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("the CSV file is empty, it needs a header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if !slices.Contains(CSVColumns, name) {
+			return nil, fmt.Errorf("unknown CSV column %q, expected %s", name, strings.Join(CSVColumns, ", "))
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["imdb_id"]; !ok {
+		return nil, errors.New("the CSV header has no imdb_id column")
+	}
+
+	return func() (*models.Movie, error) {
+		record, err := reader.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, rowError{err}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) != len(header) {
+			return nil, rowError{fmt.Errorf("expected %d fields, got %d", len(header), len(record))}
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		movie := &models.Movie{
+			ImdbID:      field("imdb_id"),
+			Title:       field("title"),
+			PosterPath:  field("poster_path"),
+			YoutubeId:   field("youtube_id"),
+			AdminReview: field("admin_review"),
+		}
+		if v := field("ranking"); v != "" {
+			if movie.Rating, err = strconv.Atoi(v); err != nil {
+				return nil, rowError{fmt.Errorf("ranking %q is not a whole number", v)}
+			}
+		}
+		if movie.Genres, err = parseGenres(field("genre")); err != nil {
+			return nil, rowError{err}
+		}
+		return movie, nil
+	}, nil
+}
+
+// parseGenres reads "id:name|id:name"
+func parseGenres(s string) ([]models.Genre, error) {
+	var genres []models.Genre
+	for _, pair := range strings.Split(s, "|") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idText, name, ok := strings.Cut(pair, ":")
+		id, err := strconv.Atoi(strings.TrimSpace(idText))
+		if !ok || err != nil {
+			return nil, fmt.Errorf("genre %q must be written id:name", pair)
+		}
+		genres = append(genres, models.Genre{GenreID: id, GenreName: strings.TrimSpace(name)})
+	}
+	return genres, nil
+}
+
+// ndjsonRows reads one movie per line, written like the body of POST /movies/. Blank lines come out as nil movies.
+func ndjsonRows(r io.Reader) func() (*models.Movie, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), maxNDJSONLine)
+
+	return func() (*models.Movie, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			return nil, nil
+		}
+		var movie models.Movie
+		if err := json.Unmarshal([]byte(line), &movie); err != nil {
+			return nil, rowError{fmt.Errorf("invalid JSON: %w", err)}
+		}
+		return &movie, nil
+	}
+}
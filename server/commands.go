@@ -0,0 +1,382 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/archive"
+	"github.com/ImranullahKhann/movie-streaming-app/server/catalogue"
+	db "github.com/ImranullahKhann/movie-streaming-app/server/database"
+	"github.com/ImranullahKhann/movie-streaming-app/server/migrations"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	migrateUsage      = "migrate [status | up [version] | down [version]]"
+	importMoviesUsage = "import-movies [--dry-run] [--format csv|ndjson] <file>"
+	exportUsage       = "export [--collections name,...] [--without-passwords] <file[.gz]>"
+	importUsage       = "import [--collections name,...] <file[.gz]>"
+)
+
+type command struct {
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+// commands are maintenance tasks run with `server <name> [args]` instead of starting the API
+var commands = map[string]command{
+	"migrate": {
+		usage: migrateUsage,
+		run:   migrateCommand,
+	},
+	"import-movies": {
+		usage: importMoviesUsage,
+		run:   importMoviesCommand,
+	},
+	"export": {
+		usage: exportUsage,
+		run:   exportCommand,
+	},
+	"import": {
+		usage: importUsage,
+		run:   importCommand,
+	},
+}
+
+func runCommand(ctx context.Context, name string, args []string) error {
+	cmd, ok := commands[name]
+	if !ok {
+		usages := make([]string, 0, len(commands))
+		for _, c := range commands {
+			usages = append(usages, "server "+c.usage)
+		}
+		slices.Sort(usages)
+		return fmt.Errorf("unknown command %q, usage:\n%s", name, strings.Join(usages, "\n"))
+	}
+	return cmd.run(ctx, args)
+}
+
+// connectMongo opens the database the commands work on, they make no sense with DB_DRIVER=memory
+func connectMongo(ctx context.Context) (*mongo.Database, func(), error) {
+	if os.Getenv("DB_DRIVER") == "memory" {
+		return nil, nil, errors.New("this command needs MongoDB, DB_DRIVER is memory")
+	}
+	client, err := db.ConnectDB()
+	if err != nil {
+		return nil, nil, err
+	}
+	return db.OpenDatabase(client), func() { client.Disconnect(context.Background()) }, nil
+}
+
+// migrateCommand shows the migration status, applies the pending migrations (up to a version) or rolls back the
+// latest one (or every one above a version)
+func migrateCommand(ctx context.Context, args []string) error {
+	action := "up"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	target := -1
+	if len(args) > 1 {
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		target = v
+	}
+	if len(args) > 2 || action == "status" && target >= 0 {
+		return errors.New("usage: server " + migrateUsage)
+	}
+
+	database, disconnect, err := connectMongo(ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	runner := migrations.NewRunner(database)
+
+	switch action {
+	case "status":
+		statuses, err := runner.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.AppliedAt != nil {
+				state = "applied " + s.AppliedAt.Local().Format(time.DateTime)
+			}
+			fmt.Printf("%4d  %-40s  %s\n", s.Version, s.Name, state)
+		}
+		return nil
+
+	case "up":
+		done, err := runner.Up(ctx, max(target, 0))
+		for _, m := range done {
+			fmt.Printf("applied %d (%s)\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to apply")
+		}
+		return err
+
+	case "down":
+		if target < 0 {
+			// the latest applied migration only
+			statuses, err := runner.Status(ctx)
+			if err != nil {
+				return err
+			}
+			applied := slices.DeleteFunc(statuses, func(s migrations.Status) bool { return s.AppliedAt == nil })
+			if len(applied) == 0 {
+				fmt.Println("nothing to roll back")
+				return nil
+			}
+			target = 0
+			if len(applied) > 1 {
+				target = applied[len(applied)-2].Version
+			}
+		}
+		done, err := runner.Down(ctx, target)
+		for _, m := range done {
+			fmt.Printf("rolled back %d (%s)\n", m.Version, m.Name)
+		}
+		if err == nil && len(done) == 0 {
+			fmt.Println("nothing to roll back")
+		}
+		return err
+	}
+	return errors.New("usage: server " + migrateUsage)
+}
+
+// checkMigrations refuses to serve a database whose documents don't have the shape this build expects.
+// MIGRATE_ON_START=true applies the pending migrations instead. A fresh database starts at the latest version.
+func checkMigrations(ctx context.Context, database *mongo.Database) error {
+	runner := migrations.NewRunner(database)
+	if _, err := runner.Baseline(ctx); err != nil {
+		return err
+	}
+	if os.Getenv("MIGRATE_ON_START") == "true" {
+		if _, err := runner.Up(ctx, 0); err != nil {
+			return err
+		}
+	}
+
+	pending, err := runner.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d database migrations pending (first: %d, %s), run `server migrate up`", len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// importMoviesCommand upserts the movies of a CSV or NDJSON file (format guessed from the extension unless given)
+// and prints the outcome of every rejected row. It fails when a row was rejected.
+func importMoviesCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import-movies", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "validate without writing")
+	format := flags.String("format", "", "csv or ndjson")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New("usage: server " + importMoviesUsage)
+	}
+	path := flags.Arg(0)
+	if *format == "" {
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".csv":
+			*format = catalogue.FormatCSV
+		case ".ndjson", ".jsonl":
+			*format = catalogue.FormatNDJSON
+		default:
+			return fmt.Errorf("can't tell the format of %s, pass --format", path)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	database, disconnect, err := connectMongo(ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	if err := checkMigrations(ctx, database); err != nil {
+		return err
+	}
+
+	importer := catalogue.NewImporter(repository.NewMongoMovieRepository(database.Collection("movies")))
+	report, err := importer.Import(ctx, *format, f, *dryRun)
+	if report == nil {
+		return err
+	}
+	for _, row := range report.Rows {
+		if row.Status == catalogue.RowRejected {
+			fmt.Printf("row %d (%s) rejected: %s\n", row.Row, row.ImdbID, row.Reason)
+		}
+	}
+	verb := "imported"
+	if report.DryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %s: %d inserted, %d updated, %d rejected\n", verb, path, report.Inserted, report.Updated, report.Rejected)
+	if err != nil {
+		return fmt.Errorf("stopped reading %s, the rows above were handled: %w", path, err)
+	}
+	if report.Rejected > 0 {
+		return fmt.Errorf("%d rows rejected", report.Rejected)
+	}
+	return nil
+}
+
+// collectionList splits a --collections value
+func collectionList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// exportCommand writes the archived collections to an NDJSON archive, gzipped when the file name ends in .gz
+func exportCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	collections := flags.String("collections", "", "comma separated collections, all of them by default")
+	withoutPasswords := flags.Bool("without-passwords", false, "leave the password hashes out")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New("usage: server " + exportUsage)
+	}
+	path := flags.Arg(0)
+
+	database, disconnect, err := connectMongo(ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	if err := checkMigrations(ctx, database); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	var zw *gzip.Writer
+	if strings.HasSuffix(path, ".gz") {
+		zw = gzip.NewWriter(f)
+		w = zw
+	}
+
+	counts, err := archive.Export(ctx, database, w, archive.ExportOptions{
+		SchemaVersion:    migrations.Latest(),
+		Collections:      collectionList(*collections),
+		WithoutPasswords: *withoutPasswords,
+	})
+	if err == nil && zw != nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	for _, c := range archive.Collections {
+		if n, ok := counts[c.Name]; ok {
+			fmt.Printf("exported %d %s\n", n, c.Name)
+		}
+	}
+	return nil
+}
+
+// importCommand restores an archive written by export into the database. It can be run again, documents already
+// there are replaced and nothing is deleted.
+func importCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	collections := flags.String("collections", "", "comma separated collections, all of the archive by default")
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 {
+		return errors.New("usage: server " + importUsage)
+	}
+	path := flags.Arg(0)
+	// fail early on a missing file, Restore opens it again for each of its passes
+	f, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	f.Close()
+
+	database, disconnect, err := connectMongo(ctx)
+	if err != nil {
+		return err
+	}
+	defer disconnect()
+	if err := checkMigrations(ctx, database); err != nil {
+		return err
+	}
+	// documents are restored by key, the unique indexes keep that fast and safe on a fresh database
+	if err := ensureIndexes(ctx, []indexedCollection{
+		{"movies", repository.NewMongoMovieRepository(database.Collection("movies"))},
+		{"users", repository.NewMongoUserRepository(database.Collection("users"))},
+		{"reviews", repository.NewMongoReviewRepository(database.Collection("reviews"))},
+		{"watch_history", repository.NewMongoWatchHistoryRepository(database.Collection("watch_history"))},
+		{"watchlist", repository.NewMongoWatchlistRepository(database.Collection("watchlist"))},
+	}); err != nil {
+		return err
+	}
+
+	results, err := archive.Restore(ctx, database, func() (io.ReadCloser, error) { return openArchive(path) }, archive.RestoreOptions{
+		SchemaVersion: migrations.Latest(),
+		Collections:   collectionList(*collections),
+	})
+	for _, c := range archive.Collections {
+		if res, ok := results[c.Name]; ok {
+			fmt.Printf("%s: %d inserted, %d updated, %d unchanged\n", c.Name, res.Inserted, res.Updated, res.Unchanged)
+		}
+	}
+	return err
+}
+
+// openArchive reads an archive file, decompressing it when its name ends in .gz
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return f, nil
+	}
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return gzipFile{zr, f}, nil
+}
+
+// gzipFile closes both the gzip stream and the file under it
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
@@ -0,0 +1,161 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultContinueWatching = 10
+	maxContinueWatching     = 50
+)
+
+type HistoryController struct {
+	history  repository.WatchHistoryRepository
+	movies   repository.MovieRepository
+	validate *validator.Validate
+}
+
+func NewHistoryController(history repository.WatchHistoryRepository, movies repository.MovieRepository) *HistoryController {
+	return &HistoryController{history: history, movies: movies, validate: validator.New()}
+}
+
+// ReportProgress records the playback position of the user, players call it periodically while playing
+func (hc *HistoryController) ReportProgress(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+	email := c.GetString("userEmail")
+
+	var req struct {
+		Position float64 `json:"position" validate:"gte=0"`
+		Duration float64 `json:"duration" validate:"gt=0"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if err := hc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// the movie is only looked up on the first report, later ones are served from the history
+	if _, err := hc.history.Find(ctx, email, imdbID); errors.Is(err, repository.ErrNotFound) {
+		if _, err := hc.movies.FindByImdbID(ctx, imdbID); err != nil {
+			if errors.Is(err, repository.ErrNotFound) {
+				c.JSON(404, gin.H{"error": "Movie not found"})
+				return
+			}
+			c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+			return
+		}
+	} else if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	progress := models.WatchProgress{
+		UserEmail: email,
+		ImdbID:    imdbID,
+		Position:  min(req.Position, req.Duration),
+		Duration:  req.Duration,
+		Finished:  req.Position >= req.Duration*models.FinishedRatio,
+		UpdatedAt: time.Now(),
+	}
+	if err := hc.history.Save(ctx, progress); err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"progress": progress})
+}
+
+// GetProgress returns the resume point of the user in a movie
+func (hc *HistoryController) GetProgress(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	progress, err := hc.history.Find(ctx, c.GetString("userEmail"), c.Param("imdbID"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "No progress recorded for this movie"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"progress": progress})
+}
+
+// GetHistory returns the resume points of every title the user played, most recent first
+func (hc *HistoryController) GetHistory(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	history, err := hc.history.FindByUser(ctx, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"history": history})
+}
+
+// ContinueWatching lists the titles the user started but didn't finish, most recently watched first, along with
+// the movies themselves
+func (hc *HistoryController) ContinueWatching(c *gin.Context) {
+	limit := int64(defaultContinueWatching)
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || l < 1 || l > maxContinueWatching {
+			c.JSON(400, gin.H{"error": "Invalid Request", "details": "limit must be between 1 and " + strconv.Itoa(maxContinueWatching)})
+			return
+		}
+		limit = l
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	history, err := hc.history.FindInProgress(ctx, c.GetString("userEmail"), limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	ids := make([]string, 0, len(history))
+	for _, p := range history {
+		ids = append(ids, p.ImdbID)
+	}
+	movies, err := hc.movies.FindByImdbIDs(ctx, ids)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	byID := make(map[string]models.Movie, len(movies))
+	for _, m := range movies {
+		byID[m.ImdbID] = m
+	}
+
+	items := make([]gin.H, 0, len(history))
+	for _, p := range history {
+		movie, ok := byID[p.ImdbID]
+		if !ok {
+			// removed from the catalogue since
+			continue
+		}
+		items = append(items, gin.H{
+			"movie":      movie,
+			"position":   p.Position,
+			"duration":   p.Duration,
+			"updated_at": p.UpdatedAt,
+		})
+	}
+	c.JSON(200, gin.H{"continue_watching": items})
+}
@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/catalogue"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// maxImportSize bounds the body of an import request, larger catalogues go through the CLI or several requests
+const maxImportSize = 32 << 20
+
+type ImportController struct {
+	importer *catalogue.Importer
+}
+
+func NewImportController(movies repository.MovieRepository) *ImportController {
+	return &ImportController{importer: catalogue.NewImporter(movies)}
+}
+
+// importFormats maps the content types accepted by ImportMovies to import formats
+var importFormats = map[string]string{
+	"text/csv":             catalogue.FormatCSV,
+	"application/x-ndjson": catalogue.FormatNDJSON,
+	"application/ndjson":   catalogue.FormatNDJSON,
+	"application/jsonl":    catalogue.FormatNDJSON,
+}
+
+// ImportMovies upserts the movies of a CSV or NDJSON body (picked by Content-Type or the format query parameter)
+// and reports the outcome of every row. dry_run=true only validates.
+func (ic *ImportController) ImportMovies(c *gin.Context) {
+	format := c.Query("format")
+	if format == "" {
+		format = importFormats[c.ContentType()]
+	}
+	if format != catalogue.FormatCSV && format != catalogue.FormatNDJSON {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Send text/csv or application/x-ndjson, or set format to csv or ndjson"})
+		return
+	}
+
+	// large files take a while, the import runs as long as the client waits
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+	report, err := ic.importer.Import(c.Request.Context(), format, body, c.Query("dry_run") == "true")
+	if err != nil {
+		// the rows read before the error are written, the report tells which
+		resp := gin.H{"error": "Invalid import file", "details": err.Error()}
+		if report != nil {
+			resp["report"] = report
+		}
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			resp["error"], resp["details"] = "Import too large", "split the file or use the import-movies command"
+			c.JSON(http.StatusRequestEntityTooLarge, resp)
+			return
+		}
+		c.JSON(400, resp)
+		return
+	}
+	c.JSON(200, gin.H{"report": report})
+}
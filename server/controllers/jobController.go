@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/jobs"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+type JobController struct {
+	jobs  repository.JobRepository
+	queue *jobs.Queue
+}
+
+func NewJobController(jobs repository.JobRepository, queue *jobs.Queue) *JobController {
+	return &JobController{jobs: jobs, queue: queue}
+}
+
+func (jc *JobController) GetJob(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job, err := jc.jobs.FindByID(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"job": job})
+}
+
+// CancelJob cancels a queued job, a running job stops shortly after (its status stays running until then)
+func (jc *JobController) CancelJob(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job, err := jc.queue.Cancel(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Job not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+	if job.Status == models.JobStatusSucceeded || job.Status == models.JobStatusFailed {
+		c.JSON(409, gin.H{"error": "Job already finished", "job": job})
+		return
+	}
+	c.JSON(202, gin.H{"job": job})
+}
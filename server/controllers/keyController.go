@@ -0,0 +1,22 @@
+package controllers
+
+import (
+	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+type KeyController struct {
+	keys *utils.KeyRing
+}
+
+func NewKeyController(keys *utils.KeyRing) *KeyController {
+	return &KeyController{keys: keys}
+}
+
+// GetJWKS publishes the public keys access tokens can be verified with. Keys are published before they start
+// signing, so caching the response for a few minutes is safe.
+func (kc *KeyController) GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": kc.keys.JWKS()})
+}
@@ -0,0 +1,382 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/jobs"
+	"github.com/ImranullahKhann/movie-streaming-app/server/media"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// packaging a feature length movie into every rendition can take a while
+const packagingTimeout = 6 * time.Hour
+
+// JobPackageHLS jobs package the source video (payload source_key) of a movie (payload imdb_id) for HLS
+const JobPackageHLS = "hls.package"
+
+const playlistContentType = "application/vnd.apple.mpegurl"
+
+type MediaController struct {
+	movies   repository.MovieRepository
+	blobs    media.BlobStore
+	packager *media.Packager
+	queue    *jobs.Queue
+	validate *validator.Validate
+}
+
+// NewMediaController enqueues packaging jobs on queue, PackageHLSJob must be registered as the JobPackageHLS handler
+func NewMediaController(movies repository.MovieRepository, blobs media.BlobStore, packager *media.Packager, queue *jobs.Queue) *MediaController {
+	return &MediaController{movies: movies, blobs: blobs, packager: packager, queue: queue, validate: validator.New()}
+}
+
+// AttachMedia references a video already present in the blob store from a movie
+func (mc *MediaController) AttachMedia(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+
+	var req struct {
+		Key   string `json:"key" validate:"required"`
+		Label string `json:"label" validate:"max=32"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if err := mc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	info, err := mc.blobs.Stat(ctx, req.Key)
+	if err != nil {
+		if errors.Is(err, media.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Media file not found"})
+			return
+		}
+		c.JSON(400, gin.H{"error": "Can't read media file", "details": err.Error()})
+		return
+	}
+	contentType, err := media.Sniff(ctx, mc.blobs, req.Key)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read media file", "details": err.Error()})
+		return
+	}
+	if !strings.HasPrefix(contentType, "video/") {
+		c.JSON(400, gin.H{"error": "Not a video file", "details": contentType})
+		return
+	}
+
+	file := models.MediaFile{
+		Key:         req.Key,
+		Label:       req.Label,
+		ContentType: contentType,
+		Size:        info.Size,
+		CreatedAt:   time.Now(),
+	}
+	if err := mc.movies.AddMedia(ctx, imdbID, file); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	c.JSON(201, gin.H{"media": file})
+}
+
+// StreamMovie serves a video of the movie with support for range requests so players can seek.
+// The quality query parameter picks a file by label, the first attached file is served otherwise.
+func (mc *MediaController) StreamMovie(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
+	cancel()
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	file, ok := pickMedia(movie.Media, c.Query("quality"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "No video available for this movie"})
+		return
+	}
+
+	// the transfer itself isn't bound by a timeout, it lasts as long as the client keeps reading
+	if err := media.Serve(c.Writer, c.Request, mc.blobs, file); err != nil {
+		if errors.Is(err, media.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Media file not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read media file"})
+	}
+}
+
+func pickMedia(files []models.MediaFile, label string) (models.MediaFile, bool) {
+	for _, f := range files {
+		if label == "" || f.Label == label {
+			return f, true
+		}
+	}
+	return models.MediaFile{}, false
+}
+
+// IngestVideo stores the uploaded "video" form file as the movie's HLS source and queues a JobPackageHLS job.
+// The movie's hls.status (or GET /jobs/:id) tells when the renditions are ready.
+func (mc *MediaController) IngestVideo(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+
+	fh, err := c.FormFile("video")
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": "a video file is required"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	_, err = mc.movies.FindByImdbID(ctx, imdbID)
+	cancel()
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	src, err := fh.Open()
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Can't read upload", "details": err.Error()})
+		return
+	}
+	defer src.Close()
+
+	// copying a large upload takes longer than a database call, the client disconnecting aborts it
+	sourceKey := imdbID + "/source/original" + strings.ToLower(path.Ext(fh.Filename))
+	if err := mc.blobs.Put(c.Request.Context(), sourceKey, src); err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't store upload", "details": err.Error()})
+		return
+	}
+
+	hls := models.HLSPackage{Status: models.HLSStatusProcessing, SourceKey: sourceKey, UpdatedAt: time.Now()}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	err = mc.movies.SetHLS(ctx, imdbID, hls)
+	cancel()
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	job, err := mc.queue.Enqueue(ctx, JobPackageHLS, map[string]string{"imdb_id": imdbID, "source_key": sourceKey}, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't queue packaging", "details": err.Error()})
+		return
+	}
+
+	c.JSON(202, gin.H{"hls": hls, "job": job})
+}
+
+// PackageHLSJob is the handler of JobPackageHLS jobs, it records the outcome on the movie
+func (mc *MediaController) PackageHLSJob(ctx context.Context, job *models.Job) error {
+	imdbID, sourceKey := job.Payload["imdb_id"], job.Payload["source_key"]
+
+	ctx, cancel := context.WithTimeout(ctx, packagingTimeout)
+	defer cancel()
+
+	hls := models.HLSPackage{Status: models.HLSStatusReady, SourceKey: sourceKey}
+	renditions, err := mc.packager.Package(ctx, imdbID, sourceKey)
+	if err != nil && jobs.Interrupted(ctx) {
+		// the movie stays processing until the job runs again
+		return err
+	}
+	if err != nil {
+		hls.Status = models.HLSStatusFailed
+		hls.Error = err.Error()
+	}
+	hls.Renditions = renditions
+	hls.UpdatedAt = time.Now()
+
+	// ctx may be cancelled already, the outcome is saved regardless
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+	if err := mc.movies.SetHLS(saveCtx, imdbID, hls); err != nil {
+		log.Printf("saving HLS renditions of %s failed: %v", imdbID, err)
+	}
+
+	// there is no point in retrying without the tools
+	if errors.Is(err, exec.ErrNotFound) {
+		return jobs.Permanent(err)
+	}
+	return err
+}
+
+// CancelPackageHLSJob is the cancel handler of JobPackageHLS jobs. IngestVideo marked the movie processing, the job
+// won't run to record an outcome so the package is marked failed and the video can be ingested again.
+func (mc *MediaController) CancelPackageHLSJob(ctx context.Context, job *models.Job) error {
+	imdbID := job.Payload["imdb_id"]
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
+	if err != nil {
+		return err
+	}
+	// the package moved on, e.g. the video was ingested again
+	if movie.HLS == nil || movie.HLS.Status != models.HLSStatusProcessing || movie.HLS.SourceKey != job.Payload["source_key"] {
+		return nil
+	}
+	return mc.movies.SetHLS(ctx, imdbID, models.HLSPackage{
+		Status:    models.HLSStatusFailed,
+		SourceKey: movie.HLS.SourceKey,
+		Error:     "packaging was cancelled",
+		UpdatedAt: time.Now(),
+	})
+}
+
+// ServeHLS serves the master playlist (master.m3u8), generated from the renditions stored on the movie, as well as
+// the variant playlists and segments of each rendition (<rendition>/index.m3u8, <rendition>/segment_0000.ts)
+func (mc *MediaController) ServeHLS(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
+	cancel()
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	if movie.HLS == nil || movie.HLS.Status != models.HLSStatusReady {
+		c.JSON(404, gin.H{"error": "No adaptive stream available for this movie"})
+		return
+	}
+
+	// playlists pass the playback signature on to the URIs they reference
+	query := c.Request.URL.RawQuery
+	if c.Param("path") == "/master.m3u8" {
+		c.Header("Cache-Control", "private, max-age=60")
+		c.Data(200, playlistContentType, []byte(media.MasterPlaylist(movie.HLS.Renditions, query)))
+		return
+	}
+
+	rendition, file, ok := media.ParseHLSPath(c.Param("path"), movie.HLS.Renditions)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+
+	if file == "index.m3u8" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		playlist, err := mc.readBlob(ctx, media.HLSKey(imdbID, rendition+"/"+file))
+		if errors.Is(err, media.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Can't read media file"})
+			return
+		}
+		c.Header("Cache-Control", "private, max-age=60")
+		c.Data(200, playlistContentType, []byte(media.SignPlaylist(string(playlist), query)))
+		return
+	}
+
+	mf := models.MediaFile{Key: media.HLSKey(imdbID, rendition+"/"+file), ContentType: media.DetectContentType(file, nil)}
+	if err := media.Serve(c.Writer, c.Request, mc.blobs, mf); err != nil {
+		if errors.Is(err, media.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read media file"})
+	}
+}
+
+func (mc *MediaController) readBlob(ctx context.Context, key string) ([]byte, error) {
+	blob, err := mc.blobs.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+	return io.ReadAll(blob)
+}
+
+// CreatePlayback mints signed URLs to stream the movie, valid for utils.PlaybackTTL. Setting bind_ip restricts
+// them to the caller's IP address. PLAYBACK_BASE_URL (e.g. a CDN) prefixes the URLs, they are relative otherwise.
+func (mc *MediaController) CreatePlayback(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+
+	var req struct {
+		BindIP bool `json:"bind_ip"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid Request"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
+	cancel()
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	hlsReady := movie.HLS != nil && movie.HLS.Status == models.HLSStatusReady
+	if len(movie.Media) == 0 && !hlsReady {
+		c.JSON(404, gin.H{"error": "No video available for this movie"})
+		return
+	}
+
+	grant := utils.PlaybackGrant{
+		ImdbID:    imdbID,
+		Email:     c.GetString("userEmail"),
+		ExpiresAt: time.Now().Add(utils.PlaybackTTL()),
+	}
+	if req.BindIP {
+		grant.IP = c.ClientIP()
+	}
+	query, err := utils.SignPlayback(grant)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't sign playback url", "details": err.Error()})
+		return
+	}
+
+	base := strings.TrimSuffix(os.Getenv("PLAYBACK_BASE_URL"), "/") + "/movies/" + url.PathEscape(imdbID)
+	resp := gin.H{"expires_at": grant.ExpiresAt}
+	if len(movie.Media) > 0 {
+		resp["stream_url"] = base + "/stream?" + query.Encode()
+	}
+	if hlsReady {
+		resp["hls_url"] = base + "/hls/master.m3u8?" + query.Encode()
+	}
+	c.JSON(201, resp)
+}
@@ -2,51 +2,164 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/recommend"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/ImranullahKhann/movie-streaming-app/server/search"
+	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+const (
+	defaultRecommendations = 5
+	maxRecommendations     = 50
+)
+
 type MovieController struct {
-	movieCollection *mongo.Collection
-	userCollection  *mongo.Collection
-	validate        *validator.Validate
+	movies      repository.MovieRepository
+	users       repository.UserRepository
+	recommender *recommend.Recommender
+	validate    *validator.Validate
 }
 
-func NewMovieController(movieCollection *mongo.Collection, userCollection *mongo.Collection) *MovieController {
+func NewMovieController(movies repository.MovieRepository, users repository.UserRepository, recommender *recommend.Recommender) *MovieController {
 	return &MovieController{
-		movieCollection: movieCollection,
-		userCollection:  userCollection,
-		validate:        validator.New(),
+		movies:      movies,
+		users:       users,
+		recommender: recommender,
+		validate:    validator.New(),
 	}
 }
 
+// GetMovies lists the catalogue one page at a time.
+// Query parameters: page, limit, genre and genre_id (comma separated or repeated), min_rating, max_rating,
+// title (prefix match) and sort (created, rating or title, prefixed with "-" for descending order).
 func (mc *MovieController) GetMovies(c *gin.Context) {
+	opts, err := movieListOptions(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid query parameter", "details": err.Error()})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	cursor, err := mc.movieCollection.Find(ctx, bson.D{})
-
+	movies, total, err := mc.movies.List(ctx, opts)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Can't access the database", "details": err})
 		return
 	}
 
-	var movies []models.Movie
-	if err = cursor.All(ctx, &movies); err != nil {
-		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+	if movies == nil {
+		movies = []models.Movie{}
+	}
+
+	resp := pageEnvelope(c, opts.Page, opts.Limit, total)
+	resp["movies"] = movies
+	c.JSON(200, resp)
+}
+
+func movieListOptions(c *gin.Context) (repository.MovieListOptions, error) {
+	var opts repository.MovieListOptions
+	var err error
+
+	opts.Page, opts.Limit, err = pageParams(c)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Filter.GenreNames = queryList(c, "genre")
+	for _, v := range queryList(c, "genre_id") {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return opts, fmt.Errorf("genre_id must be an integer")
+		}
+		opts.Filter.GenreIDs = append(opts.Filter.GenreIDs, id)
+	}
+
+	for param, dst := range map[string]*int{"min_rating": &opts.Filter.MinRating, "max_rating": &opts.Filter.MaxRating} {
+		if v := c.Query(param); v != "" {
+			*dst, err = strconv.Atoi(v)
+			if err != nil || *dst < 1 || *dst > 10 {
+				return opts, fmt.Errorf("%s must be between 1 and 10", param)
+			}
+		}
+	}
+	if opts.Filter.MaxRating > 0 && opts.Filter.MinRating > opts.Filter.MaxRating {
+		return opts, fmt.Errorf("min_rating can't be greater than max_rating")
+	}
+
+	opts.Filter.TitlePrefix = strings.TrimSpace(c.Query("title"))
+
+	sortBy := c.DefaultQuery("sort", repository.SortByCreated)
+	opts.Desc = strings.HasPrefix(sortBy, "-")
+	opts.SortBy = strings.TrimPrefix(sortBy, "-")
+	switch opts.SortBy {
+	case repository.SortByCreated, repository.SortByRating, repository.SortByTitle:
+	default:
+		return opts, fmt.Errorf("sort must be one of created, rating or title")
+	}
+
+	return opts, nil
+}
+
+// queryList collects a query parameter given either repeatedly or as a comma separated list
+func queryList(c *gin.Context, key string) []string {
+	var values []string
+	for _, v := range c.QueryArray(key) {
+		for _, part := range strings.Split(v, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				values = append(values, part)
+			}
+		}
+	}
+	return values
+}
+
+// the number of candidates fetched from the repository and ranked for a single search
+const searchCandidateLimit = 500
+
+// SearchMovies ranks the catalogue against the q query parameter, tolerating typos and partially typed words.
+// Results are paginated like GetMovies and carry the matched words of the title and admin review highlighted.
+func (mc *MovieController) SearchMovies(c *gin.Context) {
+	terms := search.Terms(c.Query("q"))
+	if len(terms) == 0 {
+		c.JSON(400, gin.H{"error": "Invalid query parameter", "details": "q is required"})
+		return
+	}
+	page, limit, err := pageParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid query parameter", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	candidates, err := mc.movies.SearchCandidates(ctx, terms, searchCandidateLimit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't access the database", "details": err})
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"movies": movies,
-	})
+	results := search.Rank(candidates, terms, 0)
+	total := int64(len(results))
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+
+	resp := pageEnvelope(c, page, limit, total)
+	resp["query"] = c.Query("q")
+	resp["results"] = results[start:end]
+	c.JSON(200, resp)
 }
 
 func (mc *MovieController) GetMovie(c *gin.Context) {
@@ -54,13 +167,9 @@ func (mc *MovieController) GetMovie(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	var movie models.Movie
-
-	err := mc.movieCollection.FindOne(ctx,
-		bson.D{{Key: "imdb_id", Value: imdbID}},
-	).Decode(&movie)
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "Movie not found"})
 			return
 		}
@@ -78,6 +187,12 @@ func (mc *MovieController) AddMovie(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid Request"})
 		return
 	}
+	// media files are attached through the media endpoints once they are stored, the community score comes from reviews
+	newMovie.Media = nil
+	newMovie.HLS = nil
+	newMovie.Community = nil
+	newMovie.DeletedAt = nil
+	newMovie.Version = 0
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -88,9 +203,11 @@ func (mc *MovieController) AddMovie(c *gin.Context) {
 		return
 	}
 
-	_, err = mc.movieCollection.InsertOne(ctx, newMovie)
-
-	if err != nil {
+	if err = mc.movies.Insert(ctx, &newMovie); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(409, gin.H{"error": "A movie with this imdb_id already exists", "details": "deleted movies can be restored"})
+			return
+		}
 		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
 		return
 	}
@@ -98,53 +215,187 @@ func (mc *MovieController) AddMovie(c *gin.Context) {
 	c.JSON(201, gin.H{"message": "Movie added successfully"})
 }
 
-func (mc *MovieController) GetRecommendedMovies(c *gin.Context) {
-	userEmail, _ := c.Get("userEmail")
-	
+// ReplaceMovie replaces the catalogue fields of a movie, fields left out of the body are cleared (and fail
+// validation when required). Media, HLS renditions and the community score can't be changed this way. The last
+// replacement wins, the version in the body is ignored.
+func (mc *MovieController) ReplaceMovie(c *gin.Context) {
+	var movie models.Movie
+	if err := c.BindJSON(&movie); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+	mc.updateMovie(c, &movie, repository.AnyVersion)
+}
+
+// PatchMovie applies a JSON merge patch (RFC 7396) to a movie, the result is validated like a new movie. The patch
+// only applies to the version it was merged into, or to the version it sets, so a concurrent update answers 409
+// instead of being lost.
+func (mc *MovieController) PatchMovie(c *gin.Context) {
+	patch, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	
-	var user models.User
-	err := mc.userCollection.FindOne(ctx, bson.D{{Key: "email", Value: userEmail}}).Decode(&user)
+
+	current, err := mc.movies.FindByImdbID(ctx, c.Param("imdbID"))
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error", "details": err.Error()})
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	doc, err := json.Marshal(current)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err.Error()})
+		return
+	}
+	patched, err := utils.MergePatch(doc, patch)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": err.Error()})
 		return
 	}
 
-	var genreNames []string
-	for _, genre := range user.FavouriteGenres {
-		genreNames = append(genreNames, genre.GenreName)
+	var movie models.Movie
+	if err := json.Unmarshal(patched, &movie); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+	mc.updateMovie(c, &movie, movie.Version)
+}
+
+// updateMovie validates and stores the new version of the movie of the request, made from version
+func (mc *MovieController) updateMovie(c *gin.Context, movie *models.Movie, version int) {
+	imdbID := c.Param("imdbID")
+	if movie.ImdbID != "" && movie.ImdbID != imdbID {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": "imdb_id can't be changed"})
+		return
+	}
+	movie.ImdbID = imdbID
+	// not editable here, Update leaves them alone
+	movie.Media = nil
+	movie.HLS = nil
+	movie.Community = nil
+	movie.DeletedAt = nil
+
+	if err := mc.validate.Struct(movie); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := mc.movies.Update(ctx, movie, version); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		if errors.Is(err, repository.ErrStaleMovie) {
+			c.JSON(409, gin.H{"error": "Movie changed since it was read", "details": "fetch it again and reapply the patch"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
 	}
 
-	
-	opts := options.Find().
-		SetSort(bson.D{{Key: "rating", Value: -1}}).
-		SetLimit(5)
-	
-	filter := bson.D{{
-		Key: "genre.genre_name",
-		Value: bson.D{{Key: "$in", Value: genreNames}},
-	}}
-	
-	cursor, err := mc.movieCollection.Find(ctx, filter, opts)
+	updated, err := mc.movies.FindByImdbID(ctx, imdbID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed", "details": err.Error()})
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
 		return
 	}
+	c.JSON(200, gin.H{"movie": updated})
+}
 
-	var recommendedMovies []models.Movie
-	if err = cursor.All(ctx, &recommendedMovies); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't read data", "details": err.Error()})
+// DeleteMovie retires a movie: it disappears from listings, search and recommendations but is kept, with its media
+// and reviews, so an admin can restore it
+func (mc *MovieController) DeleteMovie(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := mc.movies.SoftDelete(ctx, c.Param("imdbID"), time.Now()); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
 		return
 	}
+	c.Status(204)
+}
 
-	if recommendedMovies == nil {
-		recommendedMovies = []models.Movie{}
+// RestoreMovie brings back a deleted movie
+func (mc *MovieController) RestoreMovie(c *gin.Context) {
+	imdbID := c.Param("imdbID")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := mc.movies.Restore(ctx, imdbID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "No deleted movie with this imdb_id"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	movie, err := mc.movies.FindByImdbID(ctx, imdbID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"movie": movie})
+}
+
+// GetRecommendedMovies returns up to limit (default 5, at most 50) movies the user hasn't watched yet, picked from
+// what users with similar tastes liked and from the user's favourite genres. Each movie carries the reason it was
+// picked and the breakdown of its score. Admins can add debug=true to get the candidate pool, rejected candidates
+// included.
+func (mc *MovieController) GetRecommendedMovies(c *gin.Context) {
+	userEmail := c.GetString("userEmail")
+
+	debug := c.Query("debug") == "true"
+	if debug && c.GetString("userRole") != models.RoleAdmin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "debug mode is restricted to admins"})
+		return
+	}
+
+	limit := defaultRecommendations
+	if v := c.Query("limit"); v != "" {
+		l, err := strconv.Atoi(v)
+		if err != nil || l < 1 || l > maxRecommendations {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameter", "details": fmt.Sprintf("limit must be between 1 and %d", maxRecommendations)})
+			return
+		}
+		limit = l
 	}
 
-	c.JSON(http.StatusOK, gin.H{"recommendedMovies": recommendedMovies})
-}
\ No newline at end of file
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := mc.users.FindByEmail(ctx, userEmail)
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error", "details": err.Error()})
+		return
+	}
+
+	explanation, err := mc.recommender.Explain(ctx, user, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed", "details": err.Error()})
+		return
+	}
+
+	resp := gin.H{"recommendedMovies": explanation.Recommendations}
+	if debug {
+		resp["debug"] = explanation
+	}
+	c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// interleavedMovies runs an update of its own right after the first read, like a concurrent request would
+type interleavedMovies struct {
+	repository.MovieRepository
+	update func()
+}
+
+func (r *interleavedMovies) FindByImdbID(ctx context.Context, imdbID string) (*models.Movie, error) {
+	movie, err := r.MovieRepository.FindByImdbID(ctx, imdbID)
+	if r.update != nil {
+		update := r.update
+		r.update = nil
+		update()
+	}
+	return movie, err
+}
+
+func newPatchTest(t *testing.T) (*gin.Engine, *interleavedMovies) {
+	t.Helper()
+	movies := &interleavedMovies{MovieRepository: repository.NewMemoryMovieRepository()}
+	movie := models.Movie{
+		ImdbID:     "tt0113277",
+		Title:      "Heat",
+		PosterPath: "https://example.com/heat.jpg",
+		YoutubeId:  "0xbBLJ1WGwQ",
+		Genres:     []models.Genre{{GenreID: 1, GenreName: "Crime"}},
+		Rating:     8,
+	}
+	if err := movies.Insert(context.Background(), &movie); err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	router.PATCH("/movies/:imdbID", NewMovieController(movies, nil, nil).PatchMovie)
+	return router, movies
+}
+
+func patch(router *gin.Engine, imdbID, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/movies/"+imdbID, strings.NewReader(body)))
+	return w
+}
+
+func storedMovie(t *testing.T, movies repository.MovieRepository) *models.Movie {
+	t.Helper()
+	movie, err := movies.FindByImdbID(context.Background(), "tt0113277")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return movie
+}
+
+func TestPatchMovie(t *testing.T) {
+	router, movies := newPatchTest(t)
+
+	w := patch(router, "tt0113277", `{"title":"Heat (1995)","admin_review":"Mann at his best"}`)
+	if w.Code != 200 {
+		t.Fatalf("PATCH = %d: %s", w.Code, w.Body)
+	}
+	var body struct {
+		Movie models.Movie `json:"movie"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Movie.Title != "Heat (1995)" || body.Movie.AdminReview != "Mann at his best" || body.Movie.Version != 1 {
+		t.Errorf("patched movie = %+v", body.Movie)
+	}
+	if body.Movie.YoutubeId != "0xbBLJ1WGwQ" || body.Movie.Rating != 8 {
+		t.Errorf("fields left out of the patch changed: %+v", body.Movie)
+	}
+
+	// null removes a member, admin_review is optional
+	if w := patch(router, "tt0113277", `{"admin_review":null}`); w.Code != 200 {
+		t.Fatalf("PATCH removing admin_review = %d: %s", w.Code, w.Body)
+	}
+	if movie := storedMovie(t, movies); movie.AdminReview != "" || movie.Version != 2 {
+		t.Errorf("after removing admin_review: %+v", movie)
+	}
+}
+
+func TestPatchMovieRejects(t *testing.T) {
+	tests := []struct {
+		name, imdbID, body string
+		want               int
+	}{
+		{"unknown movie", "tt0000000", `{"title":"x y"}`, 404},
+		{"not an object", "tt0113277", `["title"]`, 400},
+		{"not JSON", "tt0113277", `{"title":`, 400},
+		{"garbage after the patch", "tt0113277", `{"title":"x y"} garbage`, 400},
+		{"two patches", "tt0113277", `{"title":"x y"}{"rating":1}`, 400},
+		{"required field removed", "tt0113277", `{"title":null}`, 400},
+		{"invalid value", "tt0113277", `{"ranking":11}`, 400},
+		{"imdb_id changed", "tt0113277", `{"imdb_id":"tt1"}`, 400},
+		{"negative version", "tt0113277", `{"version":-1,"title":"x y"}`, 400},
+		{"stale version", "tt0113277", `{"version":3,"title":"x y"}`, 409},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, movies := newPatchTest(t)
+			if w := patch(router, tt.imdbID, tt.body); w.Code != tt.want {
+				t.Errorf("PATCH %s = %d, want %d: %s", tt.body, w.Code, tt.want, w.Body)
+			}
+			if movie := storedMovie(t, movies); movie.Title != "Heat" || movie.Version != 0 {
+				t.Errorf("the movie changed: %+v", movie)
+			}
+		})
+	}
+}
+
+func TestPatchMovieConcurrentUpdate(t *testing.T) {
+	router, movies := newPatchTest(t)
+
+	// another patch lands between this one reading the movie and writing it back
+	movies.update = func() {
+		movie := storedMovie(t, movies)
+		movie.Rating = 9
+		if err := movies.Update(context.Background(), movie, movie.Version); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if w := patch(router, "tt0113277", `{"title":"Heat (1995)"}`); w.Code != 409 {
+		t.Fatalf("PATCH after a concurrent update = %d, want 409: %s", w.Code, w.Body)
+	}
+	movie := storedMovie(t, movies)
+	if movie.Rating != 9 || movie.Title != "Heat" || movie.Version != 1 {
+		t.Errorf("stored movie = %+v, want the concurrent update only", movie)
+	}
+
+	// retrying reads the new version and goes through, keeping both changes
+	if w := patch(router, "tt0113277", `{"title":"Heat (1995)"}`); w.Code != 200 {
+		t.Fatalf("retried PATCH = %d: %s", w.Code, w.Body)
+	}
+	movie = storedMovie(t, movies)
+	if movie.Rating != 9 || movie.Title != "Heat (1995)" || movie.Version != 2 {
+		t.Errorf("stored movie = %+v, want both changes", movie)
+	}
+}
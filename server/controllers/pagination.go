@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"math"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+	// maxPage keeps the number of items skipped, (page-1)*limit, from overflowing
+	maxPage = math.MaxInt64 / maxPageLimit
+)
+
+// pageParams reads the page (1 based) and limit query parameters
+func pageParams(c *gin.Context) (page, limit int64, err error) {
+	page, limit = 1, defaultPageLimit
+
+	if v := c.Query("page"); v != "" {
+		page, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || page < 1 || page > maxPage {
+			return 0, 0, fmt.Errorf("page must be between 1 and %d", maxPage)
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		limit, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+	}
+	return page, limit, nil
+}
+
+// pageEnvelope describes where a page sits in the full result set. The next and prev links keep every
+// other query parameter of the current request and are nil at either end.
+func pageEnvelope(c *gin.Context, page, limit, total int64) gin.H {
+	totalPages := (total + limit - 1) / limit
+
+	link := func(p int64) *string {
+		q := c.Request.URL.Query()
+		q.Set("page", strconv.FormatInt(p, 10))
+		q.Set("limit", strconv.FormatInt(limit, 10))
+		l := c.Request.URL.Path + "?" + q.Encode()
+		return &l
+	}
+
+	var next, prev *string
+	if page < totalPages {
+		next = link(page + 1)
+	}
+	if page > 1 {
+		prev = link(min(page-1, max(totalPages, 1)))
+	}
+
+	return gin.H{
+		"page":        page,
+		"limit":       limit,
+		"total":       total,
+		"total_pages": totalPages,
+		"next":        next,
+		"prev":        prev,
+	}
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestPageParams(t *testing.T) {
+	tests := []struct {
+		query       string
+		page, limit int64
+		wantErr     bool
+	}{
+		{"", 1, defaultPageLimit, false},
+		{"page=3&limit=50", 3, 50, false},
+		{"page=92233720368547758&limit=100", maxPage, 100, false},
+		{"page=0", 0, 0, true},
+		{"page=-1", 0, 0, true},
+		{"page=x", 0, 0, true},
+		{"page=92233720368547759", 0, 0, true},
+		{"page=100000000000000000&limit=100", 0, 0, true},
+		{"limit=0", 0, 0, true},
+		{"limit=101", 0, 0, true},
+	}
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/movies/?"+tt.query, nil)
+
+		page, limit, err := pageParams(c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("pageParams(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if page != tt.page || limit != tt.limit {
+			t.Errorf("pageParams(%q) = %d, %d, want %d, %d", tt.query, page, limit, tt.page, tt.limit)
+		}
+	}
+}
+
+func TestSearchMoviesPages(t *testing.T) {
+	movies := repository.NewMemoryMovieRepository()
+	for _, m := range []models.Movie{
+		{ImdbID: "tt1", Title: "Star Wars", Rating: 9},
+		{ImdbID: "tt2", Title: "Star Trek", Rating: 7},
+	} {
+		if err := movies.Insert(context.Background(), &m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	router := gin.New()
+	router.GET("/movies/search", NewMovieController(movies, nil, nil).SearchMovies)
+
+	tests := []struct {
+		query       string
+		wantStatus  int
+		wantResults int
+	}{
+		{"q=star", 200, 2},
+		{"q=star&page=2&limit=1", 200, 1},
+		{"q=star&page=5&limit=1", 200, 0},
+		{"q=star&page=100000000000000000&limit=100", 400, 0},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/movies/search?"+tt.query, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("GET /movies/search?%s = %d, want %d: %s", tt.query, w.Code, tt.wantStatus, w.Body)
+			continue
+		}
+		if w.Code != 200 {
+			continue
+		}
+		var body struct {
+			Results []json.RawMessage `json:"results"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		if len(body.Results) != tt.wantResults {
+			t.Errorf("GET /movies/search?%s returned %d results, want %d", tt.query, len(body.Results), tt.wantResults)
+		}
+	}
+}
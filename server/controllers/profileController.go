@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"time"
+)
+
+type ProfileController struct {
+	users     repository.UserRepository
+	watchlist repository.WatchlistRepository
+	movies    repository.MovieRepository
+}
+
+func NewProfileController(users repository.UserRepository, watchlist repository.WatchlistRepository, movies repository.MovieRepository) *ProfileController {
+	return &ProfileController{users: users, watchlist: watchlist, movies: movies}
+}
+
+// GetProfile returns the logged in user along with their watchlist
+func (pc *ProfileController) GetProfile(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := pc.users.FindByEmail(ctx, c.GetString("userEmail"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	watchlist, err := watchlistWithMovies(ctx, pc.watchlist, pc.movies, user.Email)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	c.JSON(200, gin.H{"profile": gin.H{
+		"user_id":          user.ID.Hex(),
+		"first_name":       user.FirstName,
+		"last_name":        user.LastName,
+		"email":            user.Email,
+		"role":             user.Role,
+		"favourite_genres": user.FavouriteGenres,
+		"created_at":       user.CreatedAt,
+		"watchlist":        watchlist,
+	}})
+}
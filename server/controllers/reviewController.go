@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type ReviewController struct {
+	reviews  repository.ReviewRepository
+	movies   repository.MovieRepository
+	users    repository.UserRepository
+	validate *validator.Validate
+}
+
+func NewReviewController(reviews repository.ReviewRepository, movies repository.MovieRepository, users repository.UserRepository) *ReviewController {
+	return &ReviewController{reviews: reviews, movies: movies, users: users, validate: validator.New()}
+}
+
+type reviewRequest struct {
+	Rating int    `json:"rating" validate:"min=1,max=10"`
+	Text   string `json:"text" validate:"max=2000"`
+}
+
+// authorName shows reviewers by first name and last initial, their email stays private
+func authorName(user *models.User) string {
+	last, _ := utf8.DecodeRuneInString(user.LastName)
+	if last == utf8.RuneError {
+		return user.FirstName
+	}
+	return user.FirstName + " " + string(last) + "."
+}
+
+// GetReviews lists the reviews of a movie, newest first, along with its community score
+func (rc *ReviewController) GetReviews(c *gin.Context) {
+	page, limit, err := pageParams(c)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid query parameter", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	movie, err := rc.movies.FindByImdbID(ctx, c.Param("imdbID"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	reviews, total, err := rc.reviews.ListByMovie(ctx, movie.ImdbID, page, limit)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	community := movie.Community
+	if community == nil {
+		community = &models.CommunityScore{}
+	}
+	resp := pageEnvelope(c, page, limit, total)
+	resp["community_score"] = community
+	resp["reviews"] = reviews
+	c.JSON(200, resp)
+}
+
+// GetMyReview returns the review of the movie by the user
+func (rc *ReviewController) GetMyReview(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	review, err := rc.reviews.Find(ctx, c.Param("imdbID"), c.GetString("userEmail"))
+	if err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "You haven't reviewed this movie"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"review": review})
+}
+
+// bindReview reads and validates a review request, answering the request itself when it is invalid
+func (rc *ReviewController) bindReview(c *gin.Context) (*reviewRequest, bool) {
+	var req reviewRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return nil, false
+	}
+	req.Text = strings.TrimSpace(req.Text)
+	if err := rc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return nil, false
+	}
+	return &req, true
+}
+
+// CreateReview rates a movie, a user reviews each movie once and edits that review afterwards
+func (rc *ReviewController) CreateReview(c *gin.Context) {
+	req, ok := rc.bindReview(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	imdbID := c.Param("imdbID")
+	if _, err := rc.movies.FindByImdbID(ctx, imdbID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	user, err := rc.users.FindByEmail(ctx, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	now := time.Now()
+	review := &models.Review{
+		ImdbID:    imdbID,
+		UserEmail: user.Email,
+		Author:    authorName(user),
+		Rating:    req.Rating,
+		Text:      req.Text,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	if err := rc.reviews.Insert(ctx, review); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(409, gin.H{"error": "You already reviewed this movie"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	community, err := rc.refreshScore(ctx, imdbID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't update the community score", "details": err})
+		return
+	}
+	c.JSON(201, gin.H{"review": review, "community_score": community})
+}
+
+// UpdateReview changes the rating and text of the review of the user
+func (rc *ReviewController) UpdateReview(c *gin.Context) {
+	req, ok := rc.bindReview(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := rc.users.FindByEmail(ctx, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	imdbID := c.Param("imdbID")
+	review := &models.Review{
+		ImdbID:    imdbID,
+		UserEmail: user.Email,
+		Author:    authorName(user),
+		Rating:    req.Rating,
+		Text:      req.Text,
+		UpdatedAt: time.Now(),
+	}
+	if err := rc.reviews.Update(ctx, review); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "You haven't reviewed this movie"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	community, err := rc.refreshScore(ctx, imdbID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't update the community score", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"review": review, "community_score": community})
+}
+
+// DeleteReview removes the review of the user, taking their rating out of the community score
+func (rc *ReviewController) DeleteReview(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	imdbID := c.Param("imdbID")
+	if err := rc.reviews.Delete(ctx, imdbID, c.GetString("userEmail")); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "You haven't reviewed this movie"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	if _, err := rc.refreshScore(ctx, imdbID); err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't update the community score", "details": err})
+		return
+	}
+	c.Status(204)
+}
+
+// refreshScore recomputes the community score of a movie from its reviews rather than adjusting the stored one.
+// Reading the reviews and storing the score aren't one step: a concurrent refresh that read the reviews before
+// another write landed can store its score last. Each refresh therefore reads the reviews again after storing and
+// stores once more while they changed, so the last refresh to finish leaves a score that counts every review.
+func (rc *ReviewController) refreshScore(ctx context.Context, imdbID string) (*models.CommunityScore, error) {
+	score, err := rc.reviews.Summarize(ctx, imdbID)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if err := rc.movies.SetCommunityScore(ctx, imdbID, score); err != nil {
+			return nil, err
+		}
+		// a steady stream of reviews keeps this going until the request times out
+		current, err := rc.reviews.Summarize(ctx, imdbID)
+		if err != nil {
+			return nil, err
+		}
+		if current == score {
+			return &score, nil
+		}
+		score = current
+	}
+}
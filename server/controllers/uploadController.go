@@ -0,0 +1,292 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/media"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The upload endpoints follow the core tus protocol (https://tus.io/protocols/resumable-upload) with the
+// creation, checksum (sha256 only) and termination extensions, so off the shelf tus clients can use them.
+const (
+	tusVersion = "1.0.0"
+	// status code defined by the checksum extension
+	statusChecksumMismatch = 460
+)
+
+type UploadController struct {
+	uploads media.UploadStore
+	blobs   media.BlobStore
+	movies  repository.MovieRepository
+	maxSize int64
+}
+
+// NewUploadController limits uploads to MAX_UPLOAD_SIZE bytes (default 50 GiB)
+func NewUploadController(uploads media.UploadStore, blobs media.BlobStore, movies repository.MovieRepository) *UploadController {
+	maxSize := int64(50 << 30)
+	if v, err := strconv.ParseInt(os.Getenv("MAX_UPLOAD_SIZE"), 10, 64); err == nil && v > 0 {
+		maxSize = v
+	}
+	return &UploadController{uploads: uploads, blobs: blobs, movies: movies, maxSize: maxSize}
+}
+
+// CreateUpload starts an upload of Upload-Length bytes. Upload-Metadata must carry the imdb_id of the movie and the
+// filename, label is optional.
+func (uc *UploadController) CreateUpload(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Tus-Max-Size", strconv.FormatInt(uc.maxSize, 10))
+
+	length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": "Upload-Length must be a positive number of bytes"})
+		return
+	}
+	if length > uc.maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Upload too large"})
+		return
+	}
+
+	meta, err := parseUploadMetadata(c.GetHeader("Upload-Metadata"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": err.Error()})
+		return
+	}
+	filename := path.Base(meta["filename"])
+	if meta["imdb_id"] == "" || meta["filename"] == "" {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": "imdb_id and filename metadata are required"})
+		return
+	}
+	if !strings.HasPrefix(media.DetectContentType(filename, nil), "video/") {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Not a video file"})
+		return
+	}
+	if len(meta["label"]) > 32 {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": "label is limited to 32 characters"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := uc.movies.FindByImdbID(ctx, meta["imdb_id"]); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	upload := &models.Upload{
+		ImdbID:    meta["imdb_id"],
+		Filename:  filename,
+		Label:     meta["label"],
+		Length:    length,
+		CreatedBy: c.GetString("userEmail"),
+		CreatedAt: time.Now(),
+	}
+	if err := uc.uploads.Create(ctx, upload); err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't create upload", "details": err.Error()})
+		return
+	}
+
+	c.Header("Location", "/uploads/"+upload.ID)
+	c.Header("Upload-Offset", "0")
+	c.JSON(201, gin.H{"upload": upload})
+}
+
+// parseUploadMetadata decodes the "key base64value,key2 base64value2" format of the Upload-Metadata header
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.New("Upload-Metadata values must be base64 encoded")
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// UploadProgress answers HEAD requests with the offset to resume from
+func (uc *UploadController) UploadProgress(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+	c.Header("Cache-Control", "no-store")
+
+	upload, ok := uc.findUpload(c)
+	if !ok {
+		return
+	}
+	c.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	c.Status(200)
+}
+
+// GetUpload describes an upload, including the media file it became once complete
+func (uc *UploadController) GetUpload(c *gin.Context) {
+	upload, ok := uc.findUpload(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, gin.H{"upload": upload})
+}
+
+func (uc *UploadController) findUpload(c *gin.Context) (*models.Upload, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	upload, err := uc.uploads.Get(ctx, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, media.ErrNotFound) {
+			c.AbortWithStatusJSON(404, gin.H{"error": "Upload not found"})
+			return nil, false
+		}
+		c.AbortWithStatusJSON(500, gin.H{"error": "Can't read upload", "details": err.Error()})
+		return nil, false
+	}
+	return upload, true
+}
+
+// PatchUpload appends the request body at Upload-Offset. An optional "Upload-Checksum: sha256 <base64 digest>"
+// header makes the chunk all or nothing. The chunk completing the upload attaches the file to the movie.
+func (uc *UploadController) PatchUpload(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if c.ContentType() != "application/offset+octet-stream" {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(400, gin.H{"error": "Invalid Request", "details": "Upload-Offset is required"})
+		return
+	}
+
+	var checksum []byte
+	if h := c.GetHeader("Upload-Checksum"); h != "" {
+		algo, encoded, _ := strings.Cut(h, " ")
+		if algo != "sha256" {
+			c.JSON(400, gin.H{"error": "Unsupported checksum algorithm", "details": algo})
+			return
+		}
+		if checksum, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid Request", "details": "Upload-Checksum must be base64 encoded"})
+			return
+		}
+	}
+
+	// a chunk can be large, it may take as long as the client needs to send it
+	ctx := c.Request.Context()
+	id := c.Param("id")
+	// held until the upload is attached, so concurrent requests can't attach it twice
+	unlock, err := uc.uploads.Lock(ctx, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrNotFound):
+			c.JSON(404, gin.H{"error": "Upload not found"})
+		case errors.Is(err, media.ErrUploadLocked):
+			c.JSON(http.StatusLocked, gin.H{"error": "Upload is being written by another request"})
+		default:
+			c.JSON(500, gin.H{"error": "Couldn't lock upload", "details": err.Error()})
+		}
+		return
+	}
+	defer unlock()
+
+	newOffset, err := uc.uploads.Append(ctx, id, offset, c.Request.Body, checksum)
+	c.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if err != nil {
+		switch {
+		case errors.Is(err, media.ErrNotFound):
+			c.JSON(404, gin.H{"error": "Upload not found"})
+		case errors.Is(err, media.ErrOffsetMismatch):
+			c.JSON(409, gin.H{"error": "Upload-Offset doesn't match the upload"})
+		case errors.Is(err, media.ErrChecksumMismatch):
+			c.JSON(statusChecksumMismatch, gin.H{"error": "Checksum mismatch"})
+		case errors.Is(err, media.ErrUploadTooLarge):
+			c.JSON(400, gin.H{"error": "Chunk exceeds Upload-Length"})
+		default:
+			c.JSON(500, gin.H{"error": "Couldn't store chunk", "details": err.Error()})
+		}
+		return
+	}
+
+	upload, err := uc.uploads.Get(ctx, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read upload", "details": err.Error()})
+		return
+	}
+	// an empty PATCH on a complete upload retries attaching it, AddMedia skips a file attached by an earlier try
+	if upload.Offset == upload.Length && upload.Media == nil {
+		if err := uc.finish(ctx, upload); err != nil {
+			c.JSON(500, gin.H{"error": "Couldn't attach upload", "details": err.Error()})
+			return
+		}
+	}
+	c.Status(204)
+}
+
+// finish moves a complete upload to the blob store, checksumming it on the way, and attaches it to the movie
+func (uc *UploadController) finish(ctx context.Context, upload *models.Upload) error {
+	data, err := uc.uploads.Open(ctx, upload.ID)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	key := upload.ImdbID + "/" + upload.ID + strings.ToLower(path.Ext(upload.Filename))
+	sum := sha256.New()
+	if err := uc.blobs.Put(ctx, key, io.TeeReader(data, sum)); err != nil {
+		return err
+	}
+
+	// CreateUpload only accepts file names with a video extension
+	contentType := media.DetectContentType(upload.Filename, nil)
+
+	file := models.MediaFile{
+		Key:         key,
+		Label:       upload.Label,
+		ContentType: contentType,
+		Size:        upload.Length,
+		SHA256:      hex.EncodeToString(sum.Sum(nil)),
+		CreatedAt:   time.Now(),
+	}
+	if err := uc.movies.AddMedia(ctx, upload.ImdbID, file); err != nil {
+		return err
+	}
+	return uc.uploads.Finish(ctx, upload.ID, file)
+}
+
+// DeleteUpload abandons an upload, a complete upload stays attached to its movie
+func (uc *UploadController) DeleteUpload(c *gin.Context) {
+	c.Header("Tus-Resumable", tusVersion)
+
+	if _, ok := uc.findUpload(c); !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := uc.uploads.Delete(ctx, c.Param("id")); err != nil {
+		c.JSON(500, gin.H{"error": "Couldn't delete upload", "details": err.Error()})
+		return
+	}
+	c.Status(204)
+}
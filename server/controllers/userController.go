@@ -2,29 +2,48 @@ package controllers
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/mail"
+	"github.com/ImranullahKhann/movie-streaming-app/server/middleware"
 	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
 	"github.com/ImranullahKhann/movie-streaming-app/server/store"
 	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
-	"github.com/ImranullahKhann/movie-streaming-app/server/middleware"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"go.mongodb.org/mongo-driver/v2/bson"
-	"go.mongodb.org/mongo-driver/v2/mongo"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
 	"time"
 )
 
+const (
+	// verification links stay valid for a day
+	verificationTTL = 24 * time.Hour
+	resetTTL        = 30 * time.Minute
+	// a refresh token presented again this soon after it was rotated comes from a concurrent or retried refresh of
+	// the same client (two tabs, a timed out request), not from a thief
+	refreshReuseGrace = 10 * time.Second
+)
+
 type UserController struct {
-	userCollection *mongo.Collection
-	validate       *validator.Validate
-	rds            *store.Redis
+	users    repository.UserRepository
+	events   repository.SecurityEventRepository
+	validate *validator.Validate
+	tokens   store.TokenStore
+	mailer   mail.Sender
 }
 
-func NewUserController(collection *mongo.Collection, redisClient *store.Redis) UserController {
-	return UserController{userCollection: collection, validate: validator.New(), rds: redisClient}
+func NewUserController(users repository.UserRepository, events repository.SecurityEventRepository, tokens store.TokenStore, mailer mail.Sender) UserController {
+	return UserController{users: users, events: events, validate: validator.New(), tokens: tokens, mailer: mailer}
 }
 
+// RegisterUser is open to anyone. New accounts are viewers and can't log in until their email address is verified.
 func (uc *UserController) RegisterUser(c *gin.Context) {
 	var newUser models.User
 
@@ -36,19 +55,16 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	count, err := uc.userCollection.CountDocuments(ctx, bson.D{{Key: "email", Value: newUser.Email}})
+	newUser.Role = models.RoleViewer
+	newUser.Status = models.UserStatusPending
+	newUser.CreatedAt = time.Now()
+	newUser.UpdatedAt = time.Now()
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing user"})
-		return
-	}
-	if count > 0 {
-		c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+	if err := uc.validate.Struct(newUser); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
 		return
 	}
 
-	newUser.CreatedAt = time.Now()
-	newUser.UpdatedAt = time.Now()
 	hash, err := utils.HashPassword(newUser.Password)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Something went wrong"})
@@ -56,17 +72,212 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 	}
 	newUser.Password = hash
 
-	if err = uc.validate.Struct(newUser); err != nil {
+	// the unique email index settles concurrent registrations
+	if err := uc.users.Insert(ctx, &newUser); err != nil {
+		if errors.Is(err, repository.ErrDuplicate) {
+			c.JSON(http.StatusConflict, gin.H{"error": "User already exists"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	if err := uc.sendVerification(ctx, &newUser); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User created but the verification email couldn't be sent, please request a new one"})
+		return
+	}
+
+	c.JSON(201, gin.H{"message": "User created, check your email to verify your account"})
+}
+
+func (uc *UserController) sendVerification(ctx context.Context, user *models.User) error {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := uc.tokens.SetJTI(ctx, "verify:"+utils.HashOpaqueToken(token), user.Email, time.Now().Add(verificationTTL)); err != nil {
+		return err
+	}
+
+	link := publicURL() + "/user/verify?token=" + url.QueryEscape(token)
+	return uc.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body:    "Hi " + user.FirstName + ",\n\nOpen the link below within 24 hours to activate your account:\n" + link,
+	})
+}
+
+// VerifyEmail activates the account a verification token was issued for. Tokens can only be used once.
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "missing token"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	email, err := uc.tokens.ConsumeJTI(ctx, "verify:"+utils.HashOpaqueToken(token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	user, err := uc.users.FindByEmail(ctx, email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No such user"})
+		return
+	}
+
+	user.Status = models.UserStatusActive
+	user.UpdatedAt = time.Now()
+	if err := uc.users.Update(ctx, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Couldn't write to database"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified, you can now log in"})
+}
+
+// ResendVerification mails a new verification link to a pending account. The response is the same whether
+// or not the account exists so it can't be used to find out who is registered.
+func (uc *UserController) ResendVerification(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := uc.validate.Struct(req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
 		return
 	}
 
-	if _, err := uc.userCollection.InsertOne(ctx, newUser); err != nil {
-		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := uc.users.FindByEmail(ctx, req.Email)
+	if err == nil && user.Status == models.UserStatusPending {
+		if err := uc.sendVerification(ctx, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Couldn't send the verification email"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account is waiting for verification, a new email is on its way"})
+}
+
+// ForgotPassword mails a single use password reset link. Like ResendVerification it answers the same way
+// for unknown addresses.
+func (uc *UserController) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := uc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
 		return
 	}
 
-	c.JSON(201, gin.H{"message": "User created successfully"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := uc.users.FindByEmail(ctx, req.Email)
+	if err == nil {
+		if err := uc.sendPasswordReset(ctx, user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Couldn't send the password reset email"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email is on its way"})
+}
+
+func (uc *UserController) sendPasswordReset(ctx context.Context, user *models.User) error {
+	token, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return err
+	}
+	if err := uc.tokens.SetJTI(ctx, "reset:"+utils.HashOpaqueToken(token), user.Email, time.Now().Add(resetTTL)); err != nil {
+		return err
+	}
+
+	// the frontend shows the new password form and posts it along with the token to ResetPassword
+	link := os.Getenv("FRONTEND_ORIGIN") + "/reset-password?token=" + url.QueryEscape(token)
+	return uc.mailer.Send(ctx, mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    "Hi " + user.FirstName + ",\n\nOpen the link below within 30 minutes to choose a new password:\n" + link + "\n\nIf you didn't ask for this, you can ignore this email.",
+	})
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword. Every session of the user is
+// signed out and their other reset tokens stop working.
+func (uc *UserController) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required,min=8"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := uc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	email, err := uc.tokens.ConsumeJTI(ctx, "reset:"+utils.HashOpaqueToken(req.Token))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired token"})
+		return
+	}
+
+	user, err := uc.users.FindByEmail(ctx, email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No such user"})
+		return
+	}
+
+	hash, err := utils.HashPassword(req.Password)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Something went wrong"})
+		return
+	}
+	user.Password = hash
+	user.UpdatedAt = time.Now()
+	if err := uc.users.Update(ctx, user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Couldn't write to database"})
+		return
+	}
+
+	err = utils.RevokeAllSessions(ctx, uc.tokens, user.Email)
+	if err == nil {
+		err = utils.RevokeUserKeys(ctx, uc.tokens, user.Email, "reset:")
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password changed but existing sessions couldn't be revoked"})
+		return
+	}
+
+	utils.ClearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed, please log in again"})
+}
+
+// publicURL is where clients reach this API, used to build links sent by email
+func publicURL() string {
+	if u := os.Getenv("PUBLIC_URL"); u != "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return "http://localhost:8080"
 }
 
 func (uc *UserController) LoginUser(c *gin.Context) {
@@ -82,16 +293,12 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 		return
 	}
 
-	var user models.User
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	err := uc.userCollection.FindOne(
-		ctx,
-		bson.D{{Key: "email", Value: loginInfo.Email}},
-	).Decode(&user)
+	user, err := uc.users.FindByEmail(ctx, loginInfo.Email)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
+		if errors.Is(err, repository.ErrNotFound) {
 			c.JSON(404, gin.H{"error": "No such user"})
 			return
 		}
@@ -104,12 +311,17 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 		return
 	}
 
-	toks, err := utils.IssueTokens(loginInfo.Email)
+	if user.Status == models.UserStatusPending {
+		c.JSON(http.StatusForbidden, gin.H{"error": "email not verified"})
+		return
+	}
+
+	toks, err := utils.IssueTokens(user.Email, user.Role, "")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue tokens"})
 		return
 	}
-	if err := utils.Persist(c, uc.rds, toks); err != nil {
+	if err := utils.Persist(ctx, uc.tokens, toks, c.Request.UserAgent(), c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not persist tokens"})
 		return
 	}
@@ -124,19 +336,30 @@ func (uc *UserController) LogoutUser(c *gin.Context) {
 	defer cancel()
 
 	if acc != "" {
-		if claims, err := utils.ParseAccess(acc); err != nil {
-			_ = uc.rds.DelJTI(ctx, "access:"+claims.ID)
+		if claims, err := utils.ParseAccess(acc); err == nil {
+			_ = uc.tokens.DelJTI(ctx, "access:"+claims.ID)
+			if claims.Family != "" {
+				_ = utils.RevokeFamily(ctx, uc.tokens, claims.Family)
+			}
 		}
 	}
 	if ref != "" {
-		if claims, err := utils.ParseRefresh(ref); err != nil {
-			_ = uc.rds.DelJTI(ctx, "refresh:"+claims.ID)
+		if claims, err := utils.ParseRefresh(ref); err == nil {
+			_ = uc.tokens.DelJTI(ctx, "refresh:"+claims.ID)
+			if claims.Family != "" {
+				_ = utils.RevokeFamily(ctx, uc.tokens, claims.Family)
+			}
 		}
 	}
 	utils.ClearAuthCookies(c)
 	c.JSON(http.StatusOK, gin.H{"ok": true})
 }
 
+// RefreshTokens rotates the refresh token: the presented one is spent and a new pair of the same family is issued.
+// Spent tokens are remembered until they expire, presenting one again means it was stolen (or the thief already
+// refreshed with it) so the whole family is revoked, signing out both parties. Within refreshReuseGrace of the
+// rotation it answers 409 instead, the client retries with the tokens the first refresh set; when the token comes
+// back from another IP or user agent than the one that rotated it, that is recorded as a security event too.
 func (uc *UserController) RefreshTokens(c *gin.Context) {
 	ref, err := middleware.MustCookie(c, "refresh_token")
 	if err != nil {
@@ -150,23 +373,179 @@ func (uc *UserController) RefreshTokens(c *gin.Context) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if _, err := uc.rds.GetUserByJTI(ctx, "refresh:"+claims.ID); err != nil {
+	// the rotation markers are written in the same step as the token is spent, a concurrent refresh that finds it
+	// spent always finds them too
+	var markers map[string]time.Time
+	// tokens issued before families existed start a new one
+	if claims.Family != "" {
+		graceExp := time.Now().Add(refreshReuseGrace)
+		markers = map[string]time.Time{
+			"rotated:" + claims.ID: claims.ExpiresAt.Time,
+			"grace:" + claims.ID:   graceExp,
+			// whoever rotated it, to tell its own retries from someone else's
+			"grace:" + claims.ID + ":" + clientFingerprint(c): graceExp,
+		}
+	}
+	if _, err := uc.tokens.SwapJTI(ctx, "refresh:"+claims.ID, markers); err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not rotate tokens"})
+			return
+		}
+		if _, err := uc.tokens.GetUserByJTI(ctx, "grace:"+claims.ID); err == nil {
+			uc.handleRefreshRace(ctx, c, claims)
+			return
+		}
+		if _, err := uc.tokens.GetUserByJTI(ctx, "rotated:"+claims.ID); err == nil {
+			uc.handleRefreshReuse(ctx, c, claims)
+			return
+		}
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh revoked"})
 		return
 	}
+	for key, exp := range markers {
+		_ = uc.tokens.AddToFamily(ctx, claims.Family, key, exp)
+	}
 
-	_ = uc.rds.DelJTI(ctx, "refresh:"+claims.ID)
+	user, err := uc.users.FindByEmail(ctx, claims.Subject)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "no such user"})
+		return
+	}
 
-	toks, err := utils.IssueTokens(claims.Subject)
+	toks, err := utils.IssueTokens(user.Email, user.Role, claims.Family)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not issue new tokens"})
 		return
 	}
-	if err := utils.Persist(ctx, uc.rds, toks); err != nil {
+	if err := utils.Persist(ctx, uc.tokens, toks, c.Request.UserAgent(), c.ClientIP()); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not persist new tokens"})
 		return
 	}
 
 	utils.SetAuthCookies(c, toks)
 	c.JSON(http.StatusCreated, gin.H{"ok": true})
-}
\ No newline at end of file
+}
+
+// handleRefreshRace answers a refresh token presented again within the grace window
+func (uc *UserController) handleRefreshRace(ctx context.Context, c *gin.Context, claims *utils.Claims) {
+	if _, err := uc.tokens.GetUserByJTI(ctx, "grace:"+claims.ID+":"+clientFingerprint(c)); err != nil {
+		uc.recordSecurityEvent(ctx, c, claims, models.SecurityEventRefreshGraceReuse)
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "refresh token was just rotated, retry with the new one"})
+}
+
+func (uc *UserController) handleRefreshReuse(ctx context.Context, c *gin.Context, claims *utils.Claims) {
+	if err := utils.RevokeFamily(ctx, uc.tokens, claims.Family); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke tokens"})
+		return
+	}
+	uc.recordSecurityEvent(ctx, c, claims, models.SecurityEventRefreshReuse)
+
+	utils.ClearAuthCookies(c)
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token reuse detected, please log in again"})
+}
+
+func (uc *UserController) recordSecurityEvent(ctx context.Context, c *gin.Context, claims *utils.Claims, eventType string) {
+	event := models.SecurityEvent{
+		UserEmail: claims.Subject,
+		Type:      eventType,
+		Family:    claims.Family,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		CreatedAt: time.Now(),
+	}
+	if err := uc.events.Insert(ctx, &event); err != nil {
+		log.Printf("failed to record %s security event for %s: %v", event.Type, event.UserEmail, err)
+	}
+}
+
+// clientFingerprint identifies the client of a request by its IP and user agent
+func clientFingerprint(c *gin.Context) string {
+	sum := sha256.Sum256([]byte(c.ClientIP() + "\n" + c.Request.UserAgent()))
+	return hex.EncodeToString(sum[:16])
+}
+
+// GetSecurityEvents lists the security events recorded for the logged in user
+func (uc *UserController) GetSecurityEvents(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, err := uc.events.FindByUser(ctx, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Can't read data"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"events": events})
+}
+
+// GetSessions lists where the user is logged in, most recently active first
+func (uc *UserController) GetSessions(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	sessions, err := uc.tokens.ListSessions(ctx, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not list sessions"})
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt) })
+
+	current := c.GetString("sessionID")
+	resp := make([]gin.H, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, gin.H{
+			"id":           s.ID,
+			"device":       s.Device,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.ID == current,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"sessions": resp})
+}
+
+// RevokeSession signs out one of the user's sessions, e.g. on a lost device
+func (uc *UserController) RevokeSession(c *gin.Context) {
+	id := c.Param("id")
+	email := c.GetString("userEmail")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, err := uc.tokens.GetSession(ctx, id)
+	// someone else's session is reported as missing so session IDs can't be probed
+	if errors.Is(err, store.ErrNotFound) || (err == nil && session.UserID != email) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not read session"})
+		return
+	}
+
+	if err := utils.RevokeFamily(ctx, uc.tokens, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke session"})
+		return
+	}
+
+	if id == c.GetString("sessionID") {
+		utils.ClearAuthCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
+
+// LogoutAll signs the user out of every session, this one included
+func (uc *UserController) LogoutAll(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := utils.RevokeAllSessions(ctx, uc.tokens, c.GetString("userEmail")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "could not revoke sessions"})
+		return
+	}
+
+	utils.ClearAuthCookies(c)
+	c.JSON(http.StatusOK, gin.H{"ok": true})
+}
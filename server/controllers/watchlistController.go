@@ -0,0 +1,188 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"time"
+)
+
+// maxWatchlistSize keeps watchlists (and the profile response embedding them) a reasonable size
+const maxWatchlistSize = 200
+
+type WatchlistController struct {
+	watchlist repository.WatchlistRepository
+	movies    repository.MovieRepository
+	validate  *validator.Validate
+}
+
+func NewWatchlistController(watchlist repository.WatchlistRepository, movies repository.MovieRepository) *WatchlistController {
+	return &WatchlistController{watchlist: watchlist, movies: movies, validate: validator.New()}
+}
+
+// watchlistEntry is a watchlist item embedding its movie
+type watchlistEntry struct {
+	ImdbID  string       `json:"imdb_id"`
+	AddedAt time.Time    `json:"added_at"`
+	Movie   models.Movie `json:"movie"`
+}
+
+// watchlistWithMovies returns the watchlist of a user in order, each entry embedding its movie. Movies removed from
+// the catalogue since are left out.
+func watchlistWithMovies(ctx context.Context, watchlist repository.WatchlistRepository, movies repository.MovieRepository, email string) ([]watchlistEntry, error) {
+	list, _, err := loadWatchlist(ctx, watchlist, movies, email)
+	return list, err
+}
+
+// loadWatchlist returns the watchlist of a user like watchlistWithMovies does, along with the IMDb IDs of the entries
+// it leaves out, in order.
+func loadWatchlist(ctx context.Context, watchlist repository.WatchlistRepository, movies repository.MovieRepository, email string) ([]watchlistEntry, []string, error) {
+	items, err := watchlist.FindByUser(ctx, email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ids := make([]string, 0, len(items))
+	for _, it := range items {
+		ids = append(ids, it.ImdbID)
+	}
+	found, err := movies.FindByImdbIDs(ctx, ids)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[string]models.Movie, len(found))
+	for _, m := range found {
+		byID[m.ImdbID] = m
+	}
+
+	list := make([]watchlistEntry, 0, len(items))
+	hidden := []string{}
+	for _, it := range items {
+		movie, ok := byID[it.ImdbID]
+		if !ok {
+			hidden = append(hidden, it.ImdbID)
+			continue
+		}
+		list = append(list, watchlistEntry{ImdbID: it.ImdbID, AddedAt: it.AddedAt, Movie: movie})
+	}
+	return list, hidden, nil
+}
+
+func (wc *WatchlistController) GetWatchlist(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	list, err := watchlistWithMovies(ctx, wc.watchlist, wc.movies, c.GetString("userEmail"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"watchlist": list})
+}
+
+// AddToWatchlist appends a movie to the end of the watchlist
+func (wc *WatchlistController) AddToWatchlist(c *gin.Context) {
+	var req struct {
+		ImdbID string `json:"imdb_id" validate:"required"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if err := wc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := wc.movies.FindByImdbID(ctx, req.ImdbID); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not found"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+
+	item, err := wc.watchlist.Add(ctx, c.GetString("userEmail"), req.ImdbID, maxWatchlistSize)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrDuplicate):
+			c.JSON(409, gin.H{"error": "Movie already on the watchlist"})
+		case errors.Is(err, repository.ErrWatchlistFull):
+			c.JSON(409, gin.H{"error": "Watchlist is full", "details": fmt.Sprintf("a watchlist holds up to %d movies", maxWatchlistSize)})
+		default:
+			c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		}
+		return
+	}
+	c.JSON(201, gin.H{"item": item})
+}
+
+func (wc *WatchlistController) RemoveFromWatchlist(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := wc.watchlist.Remove(ctx, c.GetString("userEmail"), c.Param("imdbID")); err != nil {
+		if errors.Is(err, repository.ErrNotFound) {
+			c.JSON(404, gin.H{"error": "Movie not on the watchlist"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Movie removed from the watchlist"})
+}
+
+// ReorderWatchlist takes the complete watchlist, as GetWatchlist shows it, in its new order. Entries of movies removed
+// from the catalogue go last.
+func (wc *WatchlistController) ReorderWatchlist(c *gin.Context) {
+	var req struct {
+		ImdbIDs []string `json:"imdb_ids" validate:"required,unique"`
+	}
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid Request"})
+		return
+	}
+	if err := wc.validate.Struct(req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid field data", "details": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	email := c.GetString("userEmail")
+	list, hidden, err := loadWatchlist(ctx, wc.watchlist, wc.movies, email)
+	if err != nil {
+		c.JSON(500, gin.H{"error": "Can't read data", "details": err})
+		return
+	}
+	if err := wc.watchlist.Reorder(ctx, email, append(req.ImdbIDs, hidden...)); err != nil {
+		if errors.Is(err, repository.ErrWatchlistMismatch) {
+			c.JSON(409, gin.H{"error": "imdb_ids must list every movie of the watchlist once"})
+			return
+		}
+		c.JSON(500, gin.H{"error": "Couldn't write to database", "details": err})
+		return
+	}
+
+	// answer with the entries read above in their new order rather than reading the watchlist again
+	byID := make(map[string]watchlistEntry, len(list))
+	for _, entry := range list {
+		byID[entry.ImdbID] = entry
+	}
+	reordered := make([]watchlistEntry, 0, len(list))
+	for _, id := range req.ImdbIDs {
+		if entry, ok := byID[id]; ok {
+			reordered = append(reordered, entry)
+		}
+	}
+	c.JSON(200, gin.H{"watchlist": reordered})
+}
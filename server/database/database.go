@@ -30,9 +30,13 @@ func ConnectDB() (*mongo.Client, error) {
 	return client, nil
 }
 
+// OpenDatabase returns the DB_NAME database
+func OpenDatabase(client *mongo.Client) *mongo.Database {
+	return client.Database(os.Getenv("DB_NAME"))
+}
+
 func OpenCollection(client *mongo.Client, collectionName string) *mongo.Collection {
-	dbName := os.Getenv("DB_NAME")
-	collection := client.Database(dbName).Collection(collectionName)
+	collection := OpenDatabase(client).Collection(collectionName)
 
 	return collection
 }
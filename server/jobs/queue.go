@@ -0,0 +1,315 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"log"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	errCancelled = errors.New("job cancelled")
+	errShutdown  = errors.New("interrupted by shutdown")
+)
+
+// Handler does the work of a job. It must return soon after ctx is done, the job is cancelled or the server is
+// shutting down then.
+type Handler func(ctx context.Context, job *models.Job) error
+
+// CancelHandler records that a job won't run, e.g. to undo what was set up when it was queued. It is called once
+// for a job cancelled before its Handler started.
+type CancelHandler func(ctx context.Context, job *models.Job) error
+
+// Interrupted tells a handler its ctx ended because of a shutdown, the job will run again later
+func Interrupted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errShutdown)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error retrying won't fix, the job fails right away
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+type Options struct {
+	Workers      int
+	MaxAttempts  int
+	Lease        time.Duration // a job whose worker stops renewing the lease is picked up again once it expires
+	PollInterval time.Duration
+	BaseBackoff  time.Duration // wait before the first retry, doubled for every further one
+	MaxBackoff   time.Duration
+}
+
+// DefaultOptions reads the number of workers from JOB_WORKERS (default 4)
+func DefaultOptions() Options {
+	workers := 4
+	if v, err := strconv.Atoi(os.Getenv("JOB_WORKERS")); err == nil && v > 0 {
+		workers = v
+	}
+	return Options{
+		Workers:      workers,
+		MaxAttempts:  3,
+		Lease:        time.Minute,
+		PollInterval: 2 * time.Second,
+		BaseBackoff:  30 * time.Second,
+		MaxBackoff:   time.Hour,
+	}
+}
+
+// Queue runs jobs persisted by a JobRepository on a pool of workers. Several instances can share a repository,
+// each job is claimed by a single worker.
+type Queue struct {
+	repo     repository.JobRepository
+	opts     Options
+	handlers map[string]Handler
+	onCancel map[string]CancelHandler
+	wake     chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelCauseFunc
+}
+
+func NewQueue(repo repository.JobRepository, opts Options) *Queue {
+	return &Queue{
+		repo:     repo,
+		opts:     opts,
+		handlers: map[string]Handler{},
+		onCancel: map[string]CancelHandler{},
+		wake:     make(chan struct{}, 1),
+		running:  map[string]context.CancelCauseFunc{},
+	}
+}
+
+// Register sets the handler of a job type, it must be called before Run
+func (q *Queue) Register(jobType string, h Handler) {
+	q.handlers[jobType] = h
+}
+
+// OnCancel sets the cancel handler of a job type, it must be called before Run
+func (q *Queue) OnCancel(jobType string, h CancelHandler) {
+	q.onCancel[jobType] = h
+}
+
+// Enqueue stores a job to be run as soon as a worker is free
+func (q *Queue) Enqueue(ctx context.Context, jobType string, payload map[string]string, createdBy string) (*models.Job, error) {
+	if _, ok := q.handlers[jobType]; !ok {
+		return nil, fmt.Errorf("unknown job type %q", jobType)
+	}
+	now := time.Now()
+	job := &models.Job{
+		Type:        jobType,
+		Payload:     payload,
+		Status:      models.JobStatusQueued,
+		MaxAttempts: q.opts.MaxAttempts,
+		RunAt:       now,
+		CreatedBy:   createdBy,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := q.repo.Insert(ctx, job); err != nil {
+		return nil, err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return job, nil
+}
+
+// Cancel stops a job. A queued job is cancelled right away, a running one once its handler returns. Workers of
+// other instances notice the request when renewing their lease.
+func (q *Queue) Cancel(ctx context.Context, id string) (*models.Job, error) {
+	job, dequeued, err := q.repo.Cancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if dequeued {
+		q.cancelled(ctx, job)
+	}
+
+	q.mu.Lock()
+	if cancel, ok := q.running[id]; ok {
+		cancel(errCancelled)
+	}
+	q.mu.Unlock()
+	return job, nil
+}
+
+// Run starts the workers and blocks until ctx is done and they stopped. Jobs interrupted by the shutdown go back
+// to the queue without counting as an attempt.
+func (q *Queue) Run(ctx context.Context) {
+	types := make([]string, 0, len(q.handlers))
+	for t := range q.handlers {
+		types = append(types, t)
+	}
+	slices.Sort(types)
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx, types)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context, types []string) {
+	for ctx.Err() == nil {
+		claimCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		now := time.Now()
+		job, err := q.repo.Claim(claimCtx, types, now, now.Add(q.opts.Lease))
+		cancel()
+
+		if err != nil {
+			if !errors.Is(err, repository.ErrNotFound) && ctx.Err() == nil {
+				log.Printf("claiming a job failed: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-q.wake:
+			case <-time.After(q.opts.PollInterval):
+			}
+			continue
+		}
+		q.process(ctx, job)
+	}
+}
+
+func (q *Queue) process(ctx context.Context, job *models.Job) {
+	// the job gets its own context so a shutdown can be told apart from a cancellation
+	jobCtx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	if job.CancelRequested {
+		cancel(errCancelled)
+	}
+
+	q.mu.Lock()
+	q.running[job.ID] = cancel
+	q.mu.Unlock()
+	defer func() {
+		q.mu.Lock()
+		delete(q.running, job.ID)
+		q.mu.Unlock()
+	}()
+
+	stopHeartbeat := make(chan struct{})
+	heartbeatDone := make(chan struct{})
+	go func() {
+		defer close(heartbeatDone)
+		q.heartbeat(ctx, job, cancel, stopHeartbeat)
+	}()
+
+	err := errCancelled
+	started := jobCtx.Err() == nil
+	if started {
+		err = q.call(jobCtx, job)
+	}
+	close(stopHeartbeat)
+	<-heartbeatDone
+
+	// record the outcome even when shutting down
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+	if !started {
+		q.cancelled(saveCtx, job)
+	}
+
+	var saveErr error
+	switch cause := context.Cause(jobCtx); {
+	case err == nil:
+		saveErr = q.repo.Finish(saveCtx, job.ID, job.Lease, models.JobStatusSucceeded, "")
+	case errors.Is(cause, errCancelled):
+		saveErr = q.repo.Finish(saveCtx, job.ID, job.Lease, models.JobStatusCancelled, errCancelled.Error())
+	case errors.Is(cause, errShutdown):
+		saveErr = q.repo.Retry(saveCtx, job.ID, job.Lease, job.Attempts-1, time.Now(), errShutdown.Error())
+	case errors.As(err, &permanentError{}) || job.Attempts >= job.MaxAttempts:
+		log.Printf("job %s (%s) failed: %v", job.ID, job.Type, err)
+		saveErr = q.repo.Finish(saveCtx, job.ID, job.Lease, models.JobStatusFailed, err.Error())
+	default:
+		saveErr = q.repo.Retry(saveCtx, job.ID, job.Lease, job.Attempts, time.Now().Add(q.backoff(job.Attempts)), err.Error())
+	}
+	switch {
+	case errors.Is(saveErr, repository.ErrNotFound):
+		log.Printf("job %s was claimed by another worker while this one ran it, its outcome is dropped", job.ID)
+	case saveErr != nil:
+		log.Printf("saving the outcome of job %s failed: %v", job.ID, saveErr)
+	}
+}
+
+// cancelled runs the cancel handler of a job that won't run, if its type has one
+func (q *Queue) cancelled(ctx context.Context, job *models.Job) {
+	h, ok := q.onCancel[job.Type]
+	if !ok {
+		return
+	}
+	if err := h(ctx, job); err != nil {
+		log.Printf("cleaning up after cancelled job %s (%s) failed: %v", job.ID, job.Type, err)
+	}
+}
+
+// call runs the handler, turning a panic into an error so one bad job doesn't take the worker down
+func (q *Queue) call(ctx context.Context, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return q.handlers[job.Type](ctx, job)
+}
+
+// heartbeat renews the lease of a job until stop is closed, cancelling the job when cancellation was requested
+// (possibly through another instance), the job was claimed by another worker after the lease expired or the queue
+// shuts down
+func (q *Queue) heartbeat(ctx context.Context, claimed *models.Job, cancel context.CancelCauseFunc, stop <-chan struct{}) {
+	ticker := time.NewTicker(q.opts.Lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ctx.Done():
+			cancel(errShutdown)
+			<-stop
+			return
+		case <-ticker.C:
+			extendCtx, extendCancel := context.WithTimeout(ctx, 10*time.Second)
+			job, err := q.repo.Extend(extendCtx, claimed.ID, claimed.Lease, time.Now().Add(q.opts.Lease))
+			extendCancel()
+			switch {
+			case errors.Is(err, repository.ErrNotFound):
+				cancel(errCancelled)
+			case err != nil && ctx.Err() == nil:
+				log.Printf("renewing the lease of job %s failed: %v", claimed.ID, err)
+			case err == nil && job.CancelRequested:
+				cancel(errCancelled)
+			}
+		}
+	}
+}
+
+// backoff grows exponentially with the attempts made, with some jitter so failed jobs don't retry in lockstep
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.opts.BaseBackoff
+	for i := 1; i < attempts && d < q.opts.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, q.opts.MaxBackoff)
+	return d + rand.N(d/5+1)
+}
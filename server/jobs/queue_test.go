@@ -0,0 +1,302 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testJob = "test"
+
+func newTestQueue() (*Queue, *repository.MemoryJobRepository) {
+	repo := repository.NewMemoryJobRepository()
+	return NewQueue(repo, Options{
+		Workers:      2,
+		MaxAttempts:  3,
+		Lease:        time.Second,
+		PollInterval: 5 * time.Millisecond,
+		BaseBackoff:  time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+	}), repo
+}
+
+// start runs the queue until the returned function is called, which waits for the workers to stop
+func start(q *Queue) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.Run(ctx)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitForStatus(t *testing.T, repo repository.JobRepository, id, status string) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		job, err := repo.FindByID(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s, want %s", id, job.Status, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func enqueue(t *testing.T, q *Queue) *models.Job {
+	t.Helper()
+	job, err := q.Enqueue(context.Background(), testJob, map[string]string{"n": "1"}, "admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestQueueRetriesThenSucceeds(t *testing.T) {
+	q, repo := newTestQueue()
+	var calls atomic.Int32
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		if calls.Add(1) == 1 {
+			return errors.New("flaky")
+		}
+		return nil
+	})
+	stop := start(q)
+	defer stop()
+
+	job := waitForStatus(t, repo, enqueue(t, q).ID, models.JobStatusSucceeded)
+	if job.Attempts != 2 || calls.Load() != 2 {
+		t.Errorf("attempts = %d, calls = %d, want 2 and 2", job.Attempts, calls.Load())
+	}
+	if job.FinishedAt == nil {
+		t.Error("finished_at isn't set")
+	}
+}
+
+func TestQueueFailsAfterMaxAttempts(t *testing.T) {
+	q, repo := newTestQueue()
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		return errors.New("broken")
+	})
+	stop := start(q)
+	defer stop()
+
+	job := waitForStatus(t, repo, enqueue(t, q).ID, models.JobStatusFailed)
+	if job.Attempts != 3 || job.LastError != "broken" {
+		t.Errorf("attempts = %d, last error = %q, want 3 and %q", job.Attempts, job.LastError, "broken")
+	}
+}
+
+func TestQueuePermanentFailure(t *testing.T) {
+	q, repo := newTestQueue()
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		return Permanent(errors.New("bad input"))
+	})
+	stop := start(q)
+	defer stop()
+
+	job := waitForStatus(t, repo, enqueue(t, q).ID, models.JobStatusFailed)
+	if job.Attempts != 1 || job.LastError != "bad input" {
+		t.Errorf("attempts = %d, last error = %q, want 1 and %q", job.Attempts, job.LastError, "bad input")
+	}
+}
+
+func TestQueueHandlerPanic(t *testing.T) {
+	q, repo := newTestQueue()
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		panic("boom")
+	})
+	stop := start(q)
+	defer stop()
+
+	job := waitForStatus(t, repo, enqueue(t, q).ID, models.JobStatusFailed)
+	if job.LastError != "panic: boom" {
+		t.Errorf("last error = %q, want %q", job.LastError, "panic: boom")
+	}
+}
+
+func TestQueueCancelQueued(t *testing.T) {
+	q, repo := newTestQueue()
+	var calls, cancels atomic.Int32
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		calls.Add(1)
+		return nil
+	})
+	q.OnCancel(testJob, func(ctx context.Context, job *models.Job) error {
+		cancels.Add(1)
+		return nil
+	})
+
+	job := enqueue(t, q)
+	for range 2 {
+		cancelled, err := q.Cancel(context.Background(), job.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cancelled.Status != models.JobStatusCancelled {
+			t.Fatalf("status = %s, want %s", cancelled.Status, models.JobStatusCancelled)
+		}
+	}
+
+	stop := start(q)
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	if calls.Load() != 0 {
+		t.Errorf("the handler of a cancelled job ran %d times", calls.Load())
+	}
+	if cancels.Load() != 1 {
+		t.Errorf("the cancel handler ran %d times, want once", cancels.Load())
+	}
+	waitForStatus(t, repo, job.ID, models.JobStatusCancelled)
+}
+
+func TestQueueCancelRunning(t *testing.T) {
+	q, repo := newTestQueue()
+	started := make(chan struct{})
+	var cancels atomic.Int32
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		if Interrupted(ctx) {
+			t.Error("a cancelled job reports a shutdown")
+		}
+		return ctx.Err()
+	})
+	q.OnCancel(testJob, func(ctx context.Context, job *models.Job) error {
+		cancels.Add(1)
+		return nil
+	})
+	stop := start(q)
+	defer stop()
+
+	job := enqueue(t, q)
+	<-started
+	running, err := q.Cancel(context.Background(), job.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if running.Status != models.JobStatusRunning || !running.CancelRequested {
+		t.Errorf("Cancel returned status %s, cancel requested %v, want running and true", running.Status, running.CancelRequested)
+	}
+
+	waitForStatus(t, repo, job.ID, models.JobStatusCancelled)
+	if cancels.Load() != 0 {
+		t.Errorf("the cancel handler ran for a job whose handler started")
+	}
+}
+
+func TestQueueReclaimsExpiredLease(t *testing.T) {
+	q, repo := newTestQueue()
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error { return nil })
+	job := enqueue(t, q)
+
+	// a worker that claims the job and dies, its lease expires right away
+	now := time.Now()
+	dead, err := repo.Claim(context.Background(), []string{testJob}, now, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := start(q)
+	defer stop()
+	done := waitForStatus(t, repo, job.ID, models.JobStatusSucceeded)
+	if done.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", done.Attempts)
+	}
+
+	// the dead worker coming back can't overwrite the outcome
+	err = repo.Finish(context.Background(), dead.ID, dead.Lease, models.JobStatusFailed, "late")
+	if !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("Finish with a stale lease = %v, want ErrNotFound", err)
+	}
+	if job := waitForStatus(t, repo, job.ID, models.JobStatusSucceeded); job.LastError != "" {
+		t.Errorf("last error = %q, want none", job.LastError)
+	}
+}
+
+func TestQueueCancelRequestedBeforeReclaim(t *testing.T) {
+	q, repo := newTestQueue()
+	var calls, cancels atomic.Int32
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		calls.Add(1)
+		return nil
+	})
+	q.OnCancel(testJob, func(ctx context.Context, job *models.Job) error {
+		cancels.Add(1)
+		return nil
+	})
+	job := enqueue(t, q)
+
+	now := time.Now()
+	if _, err := repo.Claim(context.Background(), []string{testJob}, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Cancel(context.Background(), job.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := start(q)
+	defer stop()
+	waitForStatus(t, repo, job.ID, models.JobStatusCancelled)
+	if calls.Load() != 0 || cancels.Load() != 1 {
+		t.Errorf("handler ran %d times and cancel handler %d times, want 0 and 1", calls.Load(), cancels.Load())
+	}
+}
+
+func TestQueueShutdownRequeues(t *testing.T) {
+	q, repo := newTestQueue()
+	started := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	q.Register(testJob, func(ctx context.Context, job *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		interrupted <- Interrupted(ctx)
+		return ctx.Err()
+	})
+	stop := start(q)
+
+	job := enqueue(t, q)
+	<-started
+	stop()
+
+	if !<-interrupted {
+		t.Error("the handler wasn't told about the shutdown")
+	}
+	requeued := waitForStatus(t, repo, job.ID, models.JobStatusQueued)
+	if requeued.Attempts != 0 || requeued.Lease != "" {
+		t.Errorf("attempts = %d, lease = %q, want the attempt given back and no lease", requeued.Attempts, requeued.Lease)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := NewQueue(repository.NewMemoryJobRepository(), Options{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second})
+	tests := []struct {
+		attempts int
+		min      time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{10, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		d := q.backoff(tt.attempts)
+		if d < tt.min || d > tt.min+tt.min/5 {
+			t.Errorf("backoff(%d) = %v, want %v plus up to 20%% jitter", tt.attempts, d, tt.min)
+		}
+	}
+}
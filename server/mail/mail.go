@@ -0,0 +1,53 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers emails. Plug in an SMTP or provider backed implementation to actually send them.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns a sender writing messages to MAIL_LOG_FILE, or to the standard logger when it isn't set
+func New() (Sender, error) {
+	path := os.Getenv("MAIL_LOG_FILE")
+	if path == "" {
+		return NewWriterSender(log.Writer()), nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open mail log: %w", err)
+	}
+	return NewWriterSender(f), nil
+}
+
+// WriterSender writes every message to w instead of sending it, handy for local development
+type WriterSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSender(w io.Writer) *WriterSender {
+	return &WriterSender{w: w}
+}
+
+func (s *WriterSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "--- mail %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().UTC().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
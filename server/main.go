@@ -1,64 +1,338 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	cont "github.com/ImranullahKhann/movie-streaming-app/server/controllers"
 	db "github.com/ImranullahKhann/movie-streaming-app/server/database"
+	"github.com/ImranullahKhann/movie-streaming-app/server/jobs"
+	"github.com/ImranullahKhann/movie-streaming-app/server/mail"
+	"github.com/ImranullahKhann/movie-streaming-app/server/media"
 	"github.com/ImranullahKhann/movie-streaming-app/server/middleware"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/recommend"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
 	"github.com/ImranullahKhann/movie-streaming-app/server/store"
+	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	router := gin.Default()
+	// SIGINT and SIGTERM stop the server gracefully: requests in flight complete and running jobs are handed back
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for _, k := range []string{"ACCESS_SECRET", "REFRESH_SECRET"} {
-		if os.Getenv(k) == "" {
-			log.Fatalf("%s not set", k)
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
 		}
+		return
 	}
 
-	rds := store.NewRedis()
+	router := gin.Default()
+
+	if os.Getenv("REFRESH_SECRET") == "" {
+		log.Fatal("REFRESH_SECRET not set")
+	}
+	if os.Getenv("PLAYBACK_SECRET") == "" {
+		log.Fatal("PLAYBACK_SECRET not set")
+	}
 
-	dbClient, err := db.ConnectDB()
+	keyRing, err := utils.NewKeyRingFromEnv()
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Failed to load signing keys:", err)
+	}
+	utils.UseKeyRing(keyRing)
+	go keyRing.Run(ctx)
+
+	tokens := store.New()
+
+	var movieRepo repository.MovieRepository
+	var userRepo repository.UserRepository
+	var eventRepo repository.SecurityEventRepository
+	var jobRepo repository.JobRepository
+	var historyRepo repository.WatchHistoryRepository
+	var watchlistRepo repository.WatchlistRepository
+	var reviewRepo repository.ReviewRepository
+	var similarityRepo repository.SimilarityRepository
+	// DB_DRIVER=memory runs the whole API without MongoDB, data is lost on restart
+	if os.Getenv("DB_DRIVER") == "memory" {
+		movieRepo = repository.NewMemoryMovieRepository()
+		userRepo = repository.NewMemoryUserRepository()
+		eventRepo = repository.NewMemorySecurityEventRepository()
+		jobRepo = repository.NewMemoryJobRepository()
+		historyRepo = repository.NewMemoryWatchHistoryRepository()
+		watchlistRepo = repository.NewMemoryWatchlistRepository()
+		reviewRepo = repository.NewMemoryReviewRepository()
+		similarityRepo = repository.NewMemorySimilarityRepository()
+	} else {
+		dbClient, err := db.ConnectDB()
+		if err != nil {
+			log.Fatal("Failed to connect to database:", err)
+		}
+		if err := checkMigrations(context.Background(), db.OpenDatabase(dbClient)); err != nil {
+			log.Fatal(err)
+		}
+
+		mongoMovies := repository.NewMongoMovieRepository(db.OpenCollection(dbClient, "movies"))
+		mongoUsers := repository.NewMongoUserRepository(db.OpenCollection(dbClient, "users"))
+		mongoJobs := repository.NewMongoJobRepository(db.OpenCollection(dbClient, "jobs"))
+		mongoHistory := repository.NewMongoWatchHistoryRepository(db.OpenCollection(dbClient, "watch_history"))
+		mongoWatchlist := repository.NewMongoWatchlistRepository(db.OpenCollection(dbClient, "watchlist"))
+		mongoReviews := repository.NewMongoReviewRepository(db.OpenCollection(dbClient, "reviews"))
+		mongoSimilarities := repository.NewMongoSimilarityRepository(db.OpenCollection(dbClient, "movie_similarities"))
+
+		if err := ensureIndexes(context.Background(), []indexedCollection{
+			{"movies", mongoMovies},
+			{"users", mongoUsers},
+			{"jobs", mongoJobs},
+			{"watch_history", mongoHistory},
+			{"watchlist", mongoWatchlist},
+			{"reviews", mongoReviews},
+			{"movie_similarities", mongoSimilarities},
+		}); err != nil {
+			log.Fatal(err)
+		}
+
+		movieRepo = mongoMovies
+		userRepo = mongoUsers
+		eventRepo = repository.NewMongoSecurityEventRepository(db.OpenCollection(dbClient, "security_events"))
+		jobRepo = mongoJobs
+		historyRepo = mongoHistory
+		watchlistRepo = mongoWatchlist
+		reviewRepo = mongoReviews
+		similarityRepo = mongoSimilarities
+	}
+
+	if err := ensureAdmin(context.Background(), userRepo); err != nil {
+		log.Fatal("Failed to create admin user:", err)
 	}
 
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{os.Getenv("FRONTEND_ORIGIN")},
-		AllowMethods:     []string{"GET", "POST"},
-		AllowHeaders:     []string{"Content-Type", "Authorization"},
+		AllowOrigins: []string{os.Getenv("FRONTEND_ORIGIN")},
+		AllowMethods: []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD"},
+		AllowHeaders: []string{"Content-Type", "Authorization", "Range", "If-Range",
+			"Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", "Upload-Checksum"},
+		ExposeHeaders: []string{"Content-Range", "Content-Length", "Accept-Ranges", "ETag",
+			"Location", "Tus-Resumable", "Tus-Max-Size", "Upload-Offset", "Upload-Length"},
 		AllowCredentials: true,
 	}))
 
-	mc := cont.NewMovieController(db.OpenCollection(dbClient, "movies"), db.OpenCollection(dbClient, "users"))
-	uc := cont.NewUserController(db.OpenCollection(dbClient, "users"), rds)
+	mailer, err := mail.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	blobs, err := media.NewLocalStore("")
+	if err != nil {
+		log.Fatal("Failed to open media store:", err)
+	}
+
+	uploadStore, err := media.NewLocalUploadStore("")
+	if err != nil {
+		log.Fatal("Failed to open upload directory:", err)
+	}
+
+	queue := jobs.NewQueue(jobRepo, jobs.DefaultOptions())
+
+	// players report their position every few seconds, it reaches the database at most every WATCH_PROGRESS_FLUSH
+	flushEvery := 15 * time.Second
+	if d, err := time.ParseDuration(os.Getenv("WATCH_PROGRESS_FLUSH")); err == nil && d > 0 {
+		flushEvery = d
+	}
+	history := repository.NewBufferedWatchHistoryRepository(historyRepo, flushEvery)
+
+	// recommendations rely on movie similarities recomputed every RECOMMENDATION_INTERVAL
+	go recommend.NewBatch(reviewRepo, history, similarityRepo).Run(ctx)
+
+	mc := cont.NewMovieController(movieRepo, userRepo, recommend.NewRecommender(movieRepo, reviewRepo, history, similarityRepo))
+	mediac := cont.NewMediaController(movieRepo, blobs, media.NewPackager(blobs, media.NewFFmpeg()), queue)
+	uc := cont.NewUserController(userRepo, eventRepo, tokens, mailer)
+	upc := cont.NewUploadController(uploadStore, blobs, movieRepo)
+	jc := cont.NewJobController(jobRepo, queue)
+	hc := cont.NewHistoryController(history, movieRepo)
+	wc := cont.NewWatchlistController(watchlistRepo, movieRepo)
+	pc := cont.NewProfileController(userRepo, watchlistRepo, movieRepo)
+	rc := cont.NewReviewController(reviewRepo, movieRepo, userRepo)
+	ic := cont.NewImportController(movieRepo)
+
+	queue.Register(cont.JobPackageHLS, mediac.PackageHLSJob)
+	queue.OnCancel(cont.JobPackageHLS, mediac.CancelPackageHLSJob)
+
+	// every route changing the catalogue must be guarded by canEditCatalogue
+	canEditCatalogue := middleware.RequirePermission(models.PermCatalogueWrite)
 
 	movies := router.Group("/movies")
 	{
 		movies.GET("/", mc.GetMovies)
+		movies.GET("/search", mc.SearchMovies)
 		movies.GET("/:imdbID", mc.GetMovie)
-		movies.POST("/", middleware.AuthMiddleware(rds), mc.AddMovie)
-		movies.GET("/recommended/", middleware.AuthMiddleware(rds), mc.GetRecommendedMovies)
-	}	
+		movies.POST("/", middleware.AuthMiddleware(tokens), canEditCatalogue, mc.AddMovie)
+		movies.POST("/import", middleware.AuthMiddleware(tokens), middleware.RequireRole(models.RoleAdmin), ic.ImportMovies)
+		movies.PUT("/:imdbID", middleware.AuthMiddleware(tokens), canEditCatalogue, mc.ReplaceMovie)
+		movies.PATCH("/:imdbID", middleware.AuthMiddleware(tokens), canEditCatalogue, mc.PatchMovie)
+		movies.DELETE("/:imdbID", middleware.AuthMiddleware(tokens), canEditCatalogue, mc.DeleteMovie)
+		movies.POST("/:imdbID/restore", middleware.AuthMiddleware(tokens), middleware.RequireRole(models.RoleAdmin), mc.RestoreMovie)
+		movies.GET("/recommended/", middleware.AuthMiddleware(tokens), mc.GetRecommendedMovies)
+		movies.POST("/:imdbID/media", middleware.AuthMiddleware(tokens), canEditCatalogue, mediac.AttachMedia)
+		movies.POST("/:imdbID/playback", middleware.AuthMiddleware(tokens), mediac.CreatePlayback)
+		movies.GET("/:imdbID/stream", middleware.PlaybackMiddleware(), mediac.StreamMovie)
+		movies.POST("/:imdbID/hls", middleware.AuthMiddleware(tokens), canEditCatalogue, mediac.IngestVideo)
+		movies.GET("/:imdbID/hls/*path", middleware.PlaybackMiddleware(), mediac.ServeHLS)
+		movies.GET("/:imdbID/reviews", rc.GetReviews)
+		movies.POST("/:imdbID/reviews", middleware.AuthMiddleware(tokens), rc.CreateReview)
+		movies.GET("/:imdbID/reviews/mine", middleware.AuthMiddleware(tokens), rc.GetMyReview)
+		movies.PUT("/:imdbID/reviews/mine", middleware.AuthMiddleware(tokens), rc.UpdateReview)
+		movies.DELETE("/:imdbID/reviews/mine", middleware.AuthMiddleware(tokens), rc.DeleteReview)
+	}
+
+	// resumable (tus) uploads of movie files
+	uploads := router.Group("/uploads", middleware.AuthMiddleware(tokens), canEditCatalogue)
+	{
+		uploads.POST("", upc.CreateUpload)
+		uploads.HEAD("/:id", upc.UploadProgress)
+		uploads.GET("/:id", upc.GetUpload)
+		uploads.PATCH("/:id", upc.PatchUpload)
+		uploads.DELETE("/:id", upc.DeleteUpload)
+	}
+
+	jobRoutes := router.Group("/jobs", middleware.AuthMiddleware(tokens), canEditCatalogue)
+	{
+		jobRoutes.GET("/:id", jc.GetJob)
+		jobRoutes.POST("/:id/cancel", jc.CancelJob)
+	}
 
 	users := router.Group("/user")
 	{
-		users.POST("/register/", middleware.AuthMiddleware(rds), uc.RegisterUser)
+		users.POST("/register/", uc.RegisterUser)
+		users.GET("/verify", uc.VerifyEmail)
+		users.POST("/verify/resend", uc.ResendVerification)
+		users.POST("/password/forgot", uc.ForgotPassword)
+		users.POST("/password/reset", uc.ResetPassword)
 		users.POST("/login/", uc.LoginUser)
-		users.GET("/logout/", middleware.AuthMiddleware(rds), uc.LogoutUser)
+		users.GET("/logout/", middleware.AuthMiddleware(tokens), uc.LogoutUser)
+		users.GET("/security-events", middleware.AuthMiddleware(tokens), uc.GetSecurityEvents)
+		users.GET("/sessions", middleware.AuthMiddleware(tokens), uc.GetSessions)
+		users.DELETE("/sessions/:id", middleware.AuthMiddleware(tokens), uc.RevokeSession)
+		users.POST("/logout-all", middleware.AuthMiddleware(tokens), uc.LogoutAll)
+		users.GET("/progress", middleware.AuthMiddleware(tokens), hc.GetHistory)
+		users.GET("/progress/:imdbID", middleware.AuthMiddleware(tokens), hc.GetProgress)
+		users.PUT("/progress/:imdbID", middleware.AuthMiddleware(tokens), hc.ReportProgress)
+		users.GET("/continue-watching", middleware.AuthMiddleware(tokens), hc.ContinueWatching)
+		users.GET("/profile", middleware.AuthMiddleware(tokens), pc.GetProfile)
+		users.GET("/watchlist", middleware.AuthMiddleware(tokens), wc.GetWatchlist)
+		users.POST("/watchlist", middleware.AuthMiddleware(tokens), wc.AddToWatchlist)
+		users.PUT("/watchlist/order", middleware.AuthMiddleware(tokens), wc.ReorderWatchlist)
+		users.DELETE("/watchlist/:imdbID", middleware.AuthMiddleware(tokens), wc.RemoveFromWatchlist)
 	}
-	
+
 	router.GET("/token/refresh", uc.RefreshTokens)
+	router.GET("/.well-known/jwks.json", cont.NewKeyController(keyRing).GetJWKS)
+
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(ctx)
+	}()
+	// the flusher outlives the server so progress saved by requests finishing during the shutdown is written too
+	historyCtx, stopHistory := context.WithCancel(context.Background())
+	defer stopHistory()
+	historyDone := make(chan struct{})
+	go func() {
+		defer close(historyDone)
+		history.Run(historyCtx)
+	}()
+
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+	srv := &http.Server{Addr: ":" + port, Handler: router}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Println("Server shutdown:", err)
+	}
+	stopHistory()
+	<-queueDone
+	<-historyDone
+}
+
+type indexedCollection struct {
+	name string
+	repo interface {
+		EnsureIndexes(ctx context.Context) error
+	}
+}
 
-	router.Run() // listens on 8080 by default
+// ensureIndexes creates the indexes every collection declares, existing ones are left as they are. Unique indexes
+// enforce rules the repositories rely on (one movie per imdb_id, one user per email...), so the server must not
+// start without them.
+func ensureIndexes(ctx context.Context, collections []indexedCollection) error {
+	for _, c := range collections {
+		if err := c.repo.EnsureIndexes(ctx); err != nil {
+			if mongo.IsDuplicateKeyError(err) {
+				return fmt.Errorf("failed to create %s indexes, remove the duplicates first: %w", c.name, err)
+			}
+			return fmt.Errorf("failed to create %s indexes: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// ensureAdmin creates the ADMIN_EMAIL account with ADMIN_PASSWORD when both are set and no such user exists,
+// so a fresh deployment has someone able to grant roles.
+func ensureAdmin(ctx context.Context, users repository.UserRepository) error {
+	email, password := os.Getenv("ADMIN_EMAIL"), os.Getenv("ADMIN_PASSWORD")
+	if email == "" || password == "" {
+		return nil
+	}
+	count, err := users.CountByEmail(ctx, email)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	hash, err := utils.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	err = users.Insert(ctx, &models.User{
+		FirstName: "Admin",
+		LastName:  "Admin",
+		Email:     email,
+		Password:  hash,
+		Role:      models.RoleAdmin,
+		Status:    models.UserStatusActive,
+		CreatedAt: now,
+		UpdatedAt: now,
+	})
+	if errors.Is(err, repository.ErrDuplicate) {
+		// created by another instance starting at the same time
+		return nil
+	}
+	return err
 }
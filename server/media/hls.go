@@ -0,0 +1,199 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Packager turns a source video of the blob store into HLS renditions stored next to it
+type Packager struct {
+	blobs      BlobStore
+	transcoder Transcoder
+	ladder     []Profile
+}
+
+func NewPackager(blobs BlobStore, transcoder Transcoder) *Packager {
+	return &Packager{blobs: blobs, transcoder: transcoder, ladder: DefaultLadder}
+}
+
+// HLSKey is the blob key of a file of a movie's HLS package, e.g. HLSKey("tt1375666", "720p/index.m3u8")
+func HLSKey(imdbID, name string) string {
+	return imdbID + "/hls/" + name
+}
+
+// Package encodes sourceKey and uploads every playlist and segment under HLSKey(imdbID, ...)
+func (p *Packager) Package(ctx context.Context, imdbID, sourceKey string) ([]models.Rendition, error) {
+	input, cleanup, err := p.localCopy(ctx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	info, err := p.transcoder.Probe(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	profiles := p.profilesFor(info)
+
+	outDir, err := os.MkdirTemp("", "hls-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(outDir)
+
+	if err := p.transcoder.PackageHLS(ctx, input, outDir, profiles); err != nil {
+		return nil, err
+	}
+
+	renditions := make([]models.Rendition, 0, len(profiles))
+	for _, prof := range profiles {
+		if err := p.upload(ctx, imdbID, outDir, prof.Name); err != nil {
+			return nil, err
+		}
+		// keep the source aspect ratio like the scale filter does
+		width := prof.Width
+		if info.Height > 0 {
+			width = (info.Width*prof.Height/info.Height + 1) &^ 1
+		}
+		bitrate := prof.VideoBitrate
+		if info.HasAudio {
+			bitrate += prof.AudioBitrate
+		}
+		renditions = append(renditions, models.Rendition{
+			Name:      prof.Name,
+			Width:     width,
+			Height:    prof.Height,
+			Bandwidth: bitrate * 1000,
+			Codecs:    hlsCodecs(prof, info.HasAudio),
+		})
+	}
+	return renditions, nil
+}
+
+// profilesFor drops the renditions taller than the source, always keeping at least the smallest one
+func (p *Packager) profilesFor(info VideoInfo) []Profile {
+	var profiles []Profile
+	for _, prof := range p.ladder {
+		if info.Height == 0 || prof.Height <= info.Height {
+			profiles = append(profiles, prof)
+		}
+	}
+	if len(profiles) == 0 {
+		profiles = p.ladder[len(p.ladder)-1:]
+	}
+	return profiles
+}
+
+// localCopy gives the transcoder a file path for a blob, copying it to a temporary file unless the store is local
+func (p *Packager) localCopy(ctx context.Context, key string) (string, func(), error) {
+	if lp, ok := p.blobs.(LocalPather); ok {
+		path, err := lp.LocalPath(key)
+		return path, func() {}, err
+	}
+
+	src, err := p.blobs.Open(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp("", "source-*"+path.Ext(key))
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	if _, err := io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		cleanup()
+		return "", nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+func (p *Packager) upload(ctx context.Context, imdbID, outDir, rendition string) error {
+	files, err := os.ReadDir(filepath.Join(outDir, rendition))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := p.uploadFile(ctx, filepath.Join(outDir, rendition, f.Name()), HLSKey(imdbID, rendition+"/"+f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Packager) uploadFile(ctx context.Context, path, key string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.blobs.Put(ctx, key, f)
+}
+
+// MasterPlaylist lists the renditions of a movie, highest bandwidth first. Variant playlists are referenced relatively,
+// with query (the playback signature) appended when not empty.
+func MasterPlaylist(renditions []models.Rendition, query string) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, r := range renditions {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"%s\"\n", r.Bandwidth, r.Width, r.Height, r.Codecs)
+		b.WriteString(withQuery(r.Name+"/index.m3u8", query) + "\n")
+	}
+	return b.String()
+}
+
+// SignPlaylist appends query to every URI of a variant playlist, players resolve relative segment URIs against
+// the playlist URL but drop its query string
+func SignPlaylist(playlist, query string) string {
+	if query == "" {
+		return playlist
+	}
+	lines := strings.Split(playlist, "\n")
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines[i] = withQuery(line, query)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func withQuery(uri, query string) string {
+	if query == "" {
+		return uri
+	}
+	if strings.Contains(uri, "?") {
+		return uri + "&" + query
+	}
+	return uri + "?" + query
+}
+
+var hlsFileName = regexp.MustCompile(`^(index\.m3u8|segment_\d+\.ts)$`)
+
+// ParseHLSPath splits a path like "720p/segment_0001.ts" into its rendition and file name, refusing anything
+// the packager doesn't produce
+func ParseHLSPath(p string, renditions []models.Rendition) (rendition, file string, ok bool) {
+	rendition, file, found := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if !found || !hlsFileName.MatchString(file) {
+		return "", "", false
+	}
+	for _, r := range renditions {
+		if r.Name == rendition {
+			return rendition, file, true
+		}
+	}
+	return "", "", false
+}
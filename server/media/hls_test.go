@@ -0,0 +1,188 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeTranscoder reports a fixed source and writes a one segment playlist for each profile
+type fakeTranscoder struct {
+	info     VideoInfo
+	probeErr error
+	encoded  []string
+}
+
+func (f *fakeTranscoder) Probe(ctx context.Context, input string) (VideoInfo, error) {
+	return f.info, f.probeErr
+}
+
+func (f *fakeTranscoder) PackageHLS(ctx context.Context, input, outDir string, profiles []Profile) error {
+	for _, p := range profiles {
+		dir := filepath.Join(outDir, p.Name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		playlist := "#EXTM3U\n#EXTINF:6,\nsegment_0000.ts\n#EXT-X-ENDLIST\n"
+		if err := os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(playlist), 0o644); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, "segment_0000.ts"), []byte("ts"), 0o644); err != nil {
+			return err
+		}
+		f.encoded = append(f.encoded, p.Name)
+	}
+	return nil
+}
+
+func packageSource(t *testing.T, transcoder *fakeTranscoder) ([]models.Rendition, *LocalStore) {
+	t.Helper()
+	blobs, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(context.Background(), "tt1/source/original.mp4", strings.NewReader("video")); err != nil {
+		t.Fatal(err)
+	}
+	renditions, err := NewPackager(blobs, transcoder).Package(context.Background(), "tt1", "tt1/source/original.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return renditions, blobs
+}
+
+func TestPackageTrimsLadder(t *testing.T) {
+	tests := []struct {
+		name string
+		info VideoInfo
+		want []models.Rendition
+	}{
+		{
+			name: "full HD source",
+			info: VideoInfo{Width: 1920, Height: 1080, HasAudio: true},
+			want: []models.Rendition{
+				{Name: "1080p", Width: 1920, Height: 1080, Bandwidth: 5192000, Codecs: "avc1.4d4028,mp4a.40.2"},
+				{Name: "720p", Width: 1280, Height: 720, Bandwidth: 2928000, Codecs: "avc1.4d401f,mp4a.40.2"},
+				{Name: "480p", Width: 854, Height: 480, Bandwidth: 1528000, Codecs: "avc1.4d401f,mp4a.40.2"},
+				{Name: "360p", Width: 640, Height: 360, Bandwidth: 896000, Codecs: "avc1.4d401f,mp4a.40.2"},
+			},
+		},
+		{
+			name: "4:3 source below 1080p",
+			info: VideoInfo{Width: 960, Height: 720, HasAudio: true},
+			want: []models.Rendition{
+				{Name: "720p", Width: 960, Height: 720, Bandwidth: 2928000, Codecs: "avc1.4d401f,mp4a.40.2"},
+				{Name: "480p", Width: 640, Height: 480, Bandwidth: 1528000, Codecs: "avc1.4d401f,mp4a.40.2"},
+				{Name: "360p", Width: 480, Height: 360, Bandwidth: 896000, Codecs: "avc1.4d401f,mp4a.40.2"},
+			},
+		},
+		{
+			name: "source smaller than the ladder",
+			info: VideoInfo{Width: 320, Height: 240},
+			want: []models.Rendition{
+				{Name: "360p", Width: 480, Height: 360, Bandwidth: 800000, Codecs: "avc1.4d401f"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transcoder := &fakeTranscoder{info: tt.info}
+			renditions, blobs := packageSource(t, transcoder)
+			if !reflect.DeepEqual(renditions, tt.want) {
+				t.Errorf("renditions = %+v, want %+v", renditions, tt.want)
+			}
+			for i, r := range tt.want {
+				if transcoder.encoded[i] != r.Name {
+					t.Errorf("encoded %q, want %q", transcoder.encoded, r.Name)
+				}
+				for _, file := range []string{"index.m3u8", "segment_0000.ts"} {
+					if _, err := blobs.Stat(context.Background(), HLSKey("tt1", r.Name+"/"+file)); err != nil {
+						t.Errorf("%s/%s wasn't uploaded: %v", r.Name, file, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPackageProbeError(t *testing.T) {
+	blobs, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(context.Background(), "tt1/source/original.mp4", strings.NewReader("video")); err != nil {
+		t.Fatal(err)
+	}
+	probeErr := errors.New("no video stream found")
+	transcoder := &fakeTranscoder{probeErr: probeErr}
+	_, err = NewPackager(blobs, transcoder).Package(context.Background(), "tt1", "tt1/source/original.mp4")
+	if !errors.Is(err, probeErr) {
+		t.Errorf("Package = %v, want %v", err, probeErr)
+	}
+	if len(transcoder.encoded) != 0 {
+		t.Errorf("encoded %q after the probe failed", transcoder.encoded)
+	}
+}
+
+func TestMasterPlaylist(t *testing.T) {
+	silent, _ := packageSource(t, &fakeTranscoder{info: VideoInfo{Width: 1280, Height: 720}})
+	got := MasterPlaylist(silent, "exp=1&sig=abc")
+	want := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,CODECS=\"avc1.4d401f\"\n720p/index.m3u8?exp=1&sig=abc\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=1400000,RESOLUTION=854x480,CODECS=\"avc1.4d401f\"\n480p/index.m3u8?exp=1&sig=abc\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS=\"avc1.4d401f\"\n360p/index.m3u8?exp=1&sig=abc\n"
+	if got != want {
+		t.Errorf("MasterPlaylist =\n%s\nwant\n%s", got, want)
+	}
+
+	sound, _ := packageSource(t, &fakeTranscoder{info: VideoInfo{Width: 1280, Height: 720, HasAudio: true}})
+	if got := MasterPlaylist(sound, ""); strings.Count(got, "mp4a.40.2") != len(sound) || strings.Contains(got, "?") {
+		t.Errorf("MasterPlaylist of a source with audio =\n%s", got)
+	}
+}
+
+func TestSignPlaylist(t *testing.T) {
+	playlist := "#EXTM3U\n#EXTINF:6,\nsegment_0000.ts\n#EXTINF:6,\nsegment_0001.ts?v=2\n#EXT-X-ENDLIST\n"
+	tests := []struct {
+		query, want string
+	}{
+		{"", playlist},
+		{"exp=1&sig=abc", "#EXTM3U\n#EXTINF:6,\nsegment_0000.ts?exp=1&sig=abc\n#EXTINF:6,\nsegment_0001.ts?v=2&exp=1&sig=abc\n#EXT-X-ENDLIST\n"},
+	}
+	for _, tt := range tests {
+		if got := SignPlaylist(playlist, tt.query); got != tt.want {
+			t.Errorf("SignPlaylist(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestParseHLSPath(t *testing.T) {
+	renditions := []models.Rendition{{Name: "720p"}, {Name: "360p"}}
+	tests := []struct {
+		path            string
+		rendition, file string
+		ok              bool
+	}{
+		{"/720p/index.m3u8", "720p", "index.m3u8", true},
+		{"360p/segment_0012.ts", "360p", "segment_0012.ts", true},
+		{"/1080p/index.m3u8", "", "", false},
+		{"/720p/../../../etc/passwd", "", "", false},
+		{"/../720p/index.m3u8", "", "", false},
+		{"/720p/../360p/index.m3u8", "", "", false},
+		{"/720p/segment_0001.ts/..", "", "", false},
+		{"/720p/source.mp4", "", "", false},
+		{"/720p", "", "", false},
+		{"/master.m3u8", "", "", false},
+	}
+	for _, tt := range tests {
+		rendition, file, ok := ParseHLSPath(tt.path, renditions)
+		if rendition != tt.rendition || file != tt.file || ok != tt.ok {
+			t.Errorf("ParseHLSPath(%q) = %q, %q, %v, want %q, %q, %v", tt.path, rendition, file, ok, tt.rendition, tt.file, tt.ok)
+		}
+	}
+}
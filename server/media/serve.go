@@ -0,0 +1,89 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// video and streaming types missing from most system mime tables
+var contentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".vtt":  "text/vtt",
+	".jpg":  "image/jpeg",
+}
+
+// DetectContentType guesses a blob's type from its extension, falling back to sniffing its first bytes
+func DetectContentType(key string, head []byte) string {
+	ext := strings.ToLower(path.Ext(key))
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
+
+// Sniff reads the start of a blob and detects its content type
+func Sniff(ctx context.Context, store BlobStore, key string) (string, error) {
+	blob, err := store.Open(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	defer blob.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(blob, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return DetectContentType(key, head[:n]), nil
+}
+
+// Serve writes a media file to the response. http.ServeContent takes care of Range, If-Range, If-None-Match and
+// If-Modified-Since, answering with 206 Partial Content, 304 or 416 as needed.
+func Serve(w http.ResponseWriter, r *http.Request, store BlobStore, file models.MediaFile) error {
+	info, err := store.Stat(r.Context(), file.Key)
+	if err != nil {
+		return err
+	}
+	blob, err := store.Open(r.Context(), file.Key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	contentType := file.ContentType
+	if contentType == "" {
+		if contentType, err = Sniff(r.Context(), store, file.Key); err != nil {
+			return err
+		}
+	}
+
+	// a strong validator is required for If-Range to work, the checksum is best, size and mtime will do otherwise
+	etag := fmt.Sprintf(`"%x-%x"`, info.Size, info.ModTime.UnixNano())
+	if file.SHA256 != "" {
+		etag = `"` + file.SHA256 + `"`
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeContent(w, r, path.Base(file.Key), info.ModTime, blob)
+	return nil
+}
@@ -0,0 +1,165 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+)
+
+// Profile is a target rendition of the encoding ladder
+type Profile struct {
+	Name         string
+	Width        int
+	Height       int
+	VideoBitrate int // kbit/s
+	AudioBitrate int // kbit/s
+	Level        int // H.264 level times ten, e.g. 31 for 3.1, passed to the encoder and advertised in CODECS
+}
+
+// DefaultLadder is encoded top down, skipping the renditions taller than the source
+var DefaultLadder = []Profile{
+	{Name: "1080p", Width: 1920, Height: 1080, VideoBitrate: 5000, AudioBitrate: 192, Level: 40},
+	{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 2800, AudioBitrate: 128, Level: 31},
+	{Name: "480p", Width: 854, Height: 480, VideoBitrate: 1400, AudioBitrate: 128, Level: 31},
+	{Name: "360p", Width: 640, Height: 360, VideoBitrate: 800, AudioBitrate: 96, Level: 31},
+}
+
+// codec of the AAC LC streams produced by FFmpeg, as advertised in master playlists
+const hlsAudioCodec = "mp4a.40.2"
+
+// hlsCodecs lists the codecs of a rendition: H.264 main profile at the level of p, and AAC unless the source has no
+// sound
+func hlsCodecs(p Profile, audio bool) string {
+	codecs := fmt.Sprintf("avc1.4d40%02x", p.Level)
+	if audio {
+		codecs += "," + hlsAudioCodec
+	}
+	return codecs
+}
+
+// SegmentDuration is the target length of HLS segments in seconds
+const SegmentDuration = 6
+
+type VideoInfo struct {
+	Width    int
+	Height   int
+	Duration float64 // seconds
+	HasAudio bool
+}
+
+// Transcoder wraps the video tooling so it can be replaced by a fake in tests
+type Transcoder interface {
+	Probe(ctx context.Context, input string) (VideoInfo, error)
+	// PackageHLS encodes input into outDir/<profile name>/index.m3u8 and its segments
+	PackageHLS(ctx context.Context, input, outDir string, profiles []Profile) error
+}
+
+// FFmpeg runs the ffmpeg and ffprobe binaries, FFMPEG_PATH and FFPROBE_PATH override where they are looked up
+type FFmpeg struct {
+	FFmpegPath  string
+	FFprobePath string
+}
+
+func NewFFmpeg() *FFmpeg {
+	f := &FFmpeg{FFmpegPath: os.Getenv("FFMPEG_PATH"), FFprobePath: os.Getenv("FFPROBE_PATH")}
+	if f.FFmpegPath == "" {
+		f.FFmpegPath = "ffmpeg"
+	}
+	if f.FFprobePath == "" {
+		f.FFprobePath = "ffprobe"
+	}
+	return f
+}
+
+func (f *FFmpeg) Probe(ctx context.Context, input string) (VideoInfo, error) {
+	out, err := run(ctx, f.FFprobePath,
+		"-v", "error",
+		"-show_entries", "stream=codec_type,width,height:format=duration",
+		"-of", "json",
+		input,
+	)
+	if err != nil {
+		return VideoInfo{}, err
+	}
+
+	var probe struct {
+		Streams []struct {
+			CodecType string `json:"codec_type"`
+			Width     int    `json:"width"`
+			Height    int    `json:"height"`
+		} `json:"streams"`
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(out, &probe); err != nil {
+		return VideoInfo{}, fmt.Errorf("unexpected ffprobe output: %w", err)
+	}
+	var info VideoInfo
+	video := false
+	for _, s := range probe.Streams {
+		switch {
+		case s.CodecType == "video" && !video:
+			info.Width, info.Height = s.Width, s.Height
+			video = true
+		case s.CodecType == "audio":
+			info.HasAudio = true
+		}
+	}
+	if !video {
+		return VideoInfo{}, fmt.Errorf("no video stream found")
+	}
+	info.Duration, _ = strconv.ParseFloat(probe.Format.Duration, 64)
+	return info, nil
+}
+
+func (f *FFmpeg) PackageHLS(ctx context.Context, input, outDir string, profiles []Profile) error {
+	for _, p := range profiles {
+		dir := filepath.Join(outDir, p.Name)
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+
+		// a key frame every 2 seconds (at 24 fps) and no scene cut key frames keep segments aligned across renditions
+		_, err := run(ctx, f.FFmpegPath,
+			"-y", "-v", "error",
+			"-i", input,
+			"-vf", fmt.Sprintf("scale=-2:%d", p.Height),
+			"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main",
+			"-level:v", fmt.Sprintf("%d.%d", p.Level/10, p.Level%10),
+			"-b:v", fmt.Sprintf("%dk", p.VideoBitrate),
+			"-maxrate", fmt.Sprintf("%dk", p.VideoBitrate*107/100),
+			"-bufsize", fmt.Sprintf("%dk", p.VideoBitrate*3/2),
+			"-g", "48", "-keyint_min", "48", "-sc_threshold", "0",
+			"-c:a", "aac", "-ac", "2", "-b:a", fmt.Sprintf("%dk", p.AudioBitrate),
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(SegmentDuration),
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(dir, "segment_%04d.ts"),
+			filepath.Join(dir, "index.m3u8"),
+		)
+		if err != nil {
+			return fmt.Errorf("encoding %s: %w", p.Name, err)
+		}
+	}
+	return nil
+}
+
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := bytes.TrimSpace(stderr.Bytes()); len(msg) > 0 {
+			return nil, fmt.Errorf("%s: %w: %s", filepath.Base(name), err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return stdout.Bytes(), nil
+}
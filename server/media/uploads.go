@@ -0,0 +1,243 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+var (
+	ErrOffsetMismatch   = errors.New("upload offset mismatch")
+	ErrUploadTooLarge   = errors.New("upload exceeds its length")
+	ErrChecksumMismatch = errors.New("chunk checksum mismatch")
+	ErrUploadLocked     = errors.New("upload is being written to")
+)
+
+// UploadStore keeps the chunks of resumable uploads until they are complete
+type UploadStore interface {
+	// Create assigns upload an ID, it starts out empty
+	Create(ctx context.Context, upload *models.Upload) error
+	// Get returns ErrNotFound for unknown IDs, Offset is the number of bytes stored so far
+	Get(ctx context.Context, id string) (*models.Upload, error)
+	// Lock gives the caller the upload to itself until it calls unlock, a second caller gets ErrUploadLocked
+	// rather than waiting. Append and Finish must be called with the lock held.
+	Lock(ctx context.Context, id string) (unlock func(), err error)
+	// Append writes r at offset, which must be the current offset, and returns the new offset. When checksum is
+	// set the chunk is dropped unless its SHA-256 matches. A finished upload takes no more bytes.
+	Append(ctx context.Context, id string, offset int64, r io.Reader, checksum []byte) (int64, error)
+	// Open reads the data of an upload
+	Open(ctx context.Context, id string) (io.ReadCloser, error)
+	// Finish records the media file made of a complete upload and frees its data
+	Finish(ctx context.Context, id string, file models.MediaFile) error
+	Delete(ctx context.Context, id string) error
+}
+
+var uploadID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// LocalUploadStore keeps each upload as <id>.json (its metadata) and <id>.bin (the bytes received) in a directory.
+// The offset is the size of the data file, so whatever reached the disk before a crash can be resumed.
+type LocalUploadStore struct {
+	dir string
+
+	mu      sync.Mutex
+	writing map[string]bool
+}
+
+// NewLocalUploadStore uses UPLOAD_DIR (default "data/uploads") when dir is empty
+func NewLocalUploadStore(dir string) (*LocalUploadStore, error) {
+	if dir == "" {
+		dir = os.Getenv("UPLOAD_DIR")
+	}
+	if dir == "" {
+		dir = "data/uploads"
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &LocalUploadStore{dir: dir, writing: map[string]bool{}}, nil
+}
+
+func (s *LocalUploadStore) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *LocalUploadStore) dataPath(id string) string {
+	return filepath.Join(s.dir, id+".bin")
+}
+
+func (s *LocalUploadStore) Create(ctx context.Context, upload *models.Upload) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	upload.ID = hex.EncodeToString(b)
+	upload.Offset = 0
+
+	if err := os.WriteFile(s.dataPath(upload.ID), nil, 0o644); err != nil {
+		return err
+	}
+	return s.writeInfo(upload)
+}
+
+func (s *LocalUploadStore) writeInfo(upload *models.Upload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(upload.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(upload.ID))
+}
+
+func (s *LocalUploadStore) Get(ctx context.Context, id string) (*models.Upload, error) {
+	if !uploadID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var upload models.Upload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, err
+	}
+
+	if upload.Media != nil {
+		upload.Offset = upload.Length
+		return &upload, nil
+	}
+	fi, err := os.Stat(s.dataPath(id))
+	if err != nil {
+		return nil, err
+	}
+	upload.Offset = fi.Size()
+	return &upload, nil
+}
+
+// Lock makes sure a single request writes to an upload at a time, a second one is refused rather than queued
+func (s *LocalUploadStore) Lock(ctx context.Context, id string) (func(), error) {
+	if !uploadID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writing[id] {
+		return nil, ErrUploadLocked
+	}
+	s.writing[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.writing, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *LocalUploadStore) Append(ctx context.Context, id string, offset int64, r io.Reader, checksum []byte) (int64, error) {
+	upload, err := s.Get(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != upload.Offset {
+		return upload.Offset, ErrOffsetMismatch
+	}
+	if upload.Media != nil {
+		// its data file is gone, only an empty chunk can follow
+		var extra [1]byte
+		if n, _ := r.Read(extra[:]); n > 0 {
+			return offset, ErrUploadTooLarge
+		}
+		return offset, nil
+	}
+
+	f, err := os.OpenFile(s.dataPath(id), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return offset, err
+	}
+	defer f.Close()
+
+	var w io.Writer = f
+	var sum hash.Hash
+	if checksum != nil {
+		sum = sha256.New()
+		w = io.MultiWriter(f, sum)
+	}
+
+	// an interrupted chunk keeps the bytes that made it, the client resumes from there
+	n, copyErr := io.Copy(w, io.LimitReader(r, upload.Length-offset))
+	newOffset := offset + n
+	if copyErr == nil && newOffset == upload.Length {
+		var extra [1]byte
+		if m, _ := r.Read(extra[:]); m > 0 {
+			copyErr = ErrUploadTooLarge
+		}
+	}
+	if sum != nil && (copyErr != nil || !bytes.Equal(sum.Sum(nil), checksum)) {
+		// a chunk with a checksum is all or nothing
+		if err := f.Truncate(offset); err != nil {
+			return offset, err
+		}
+		if copyErr == nil {
+			copyErr = ErrChecksumMismatch
+		}
+		return offset, copyErr
+	}
+	if copyErr != nil {
+		return newOffset, copyErr
+	}
+	return newOffset, f.Sync()
+}
+
+func (s *LocalUploadStore) Open(ctx context.Context, id string) (io.ReadCloser, error) {
+	if !uploadID.MatchString(id) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(s.dataPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s *LocalUploadStore) Finish(ctx context.Context, id string, file models.MediaFile) error {
+	upload, err := s.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	upload.Media = &file
+	upload.Offset = upload.Length
+	if err := s.writeInfo(upload); err != nil {
+		return err
+	}
+	if err := os.Remove(s.dataPath(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalUploadStore) Delete(ctx context.Context, id string) error {
+	if !uploadID.MatchString(id) {
+		return ErrNotFound
+	}
+	for _, p := range []string{s.dataPath(id), s.infoPath(id)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,7 +18,7 @@ func bearerFromHeader(c *gin.Context) string {
 	return ""
 }
 
-func AuthMiddleware(r *store.Redis) gin.HandlerFunc {
+func AuthMiddleware(tokens store.TokenStore) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenStr, _ := c.Cookie("access_token")
 		if tokenStr == "" {
@@ -36,12 +36,14 @@ func AuthMiddleware(r *store.Redis) gin.HandlerFunc {
 		}
 
 		ctx := context.Background()
-		if _, err := r.GetUserByJTI(ctx, "access:"+claims.ID); err != nil {
+		if _, err := tokens.GetUserByJTI(ctx, "access:"+claims.ID); err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "token revoked"})
 			return
 		}
 
 		c.Set("userEmail", claims.Subject)
+		c.Set("userRole", claims.Role)
+		c.Set("sessionID", claims.Family)
 		c.Next()
 	}
 }
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/utils"
+	"github.com/gin-gonic/gin"
+	"net/http"
+)
+
+// PlaybackMiddleware guards the stream and segment routes with the signed URLs minted by POST
+// /movies/:imdbID/playback instead of the access token, so players (and CDNs) don't need the auth cookie
+func PlaybackMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		grant, err := utils.VerifyPlayback(c.Param("imdbID"), c.Request.URL.Query(), c.ClientIP())
+		if err != nil {
+			if errors.Is(err, utils.ErrPlaybackExpired) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "playback url expired"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "invalid playback url"})
+			return
+		}
+
+		c.Set("userEmail", grant.Email)
+		c.Next()
+	}
+}
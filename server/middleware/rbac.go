@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+)
+
+// RequireRole only lets through users holding one of the given roles. It must run after AuthMiddleware.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !slices.Contains(roles, c.GetString("userRole")) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient role"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission only lets through users whose role grants perm. It must run after AuthMiddleware.
+func RequirePermission(perm models.Permission) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.HasPermission(c.GetString("userRole"), perm) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "permission denied"})
+			return
+		}
+		c.Next()
+	}
+}
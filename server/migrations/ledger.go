@@ -0,0 +1,95 @@
+package migrations
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// ledger keeps the bookkeeping of a Runner: the applied versions and the lock
+type ledger interface {
+	// applied returns the recorded migrations, in no particular order
+	applied(ctx context.Context) ([]appliedMigration, error)
+	record(ctx context.Context, m appliedMigration) error
+	forget(ctx context.Context, version int) error
+	// lock returns ErrLocked while any lock is held
+	lock(ctx context.Context, owner string, at time.Time) error
+	// unlockBefore removes a lock taken before at, whoever holds it, and tells whether there was one
+	unlockBefore(ctx context.Context, at time.Time) (bool, error)
+	unlock(ctx context.Context, owner string) error
+	// fresh tells whether the database holds no documents yet, besides the bookkeeping
+	fresh(ctx context.Context) (bool, error)
+}
+
+// mongoLedger records the applied versions in the migrations collection, one document per version, along with
+// the lock
+type mongoLedger struct {
+	db         *mongo.Database
+	collection *mongo.Collection
+}
+
+func (l *mongoLedger) applied(ctx context.Context) ([]appliedMigration, error) {
+	cursor, err := l.collection.Find(ctx, bson.D{{Key: "_id", Value: bson.D{{Key: "$ne", Value: lockID}}}})
+	if err != nil {
+		return nil, err
+	}
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (l *mongoLedger) record(ctx context.Context, m appliedMigration) error {
+	_, err := l.collection.InsertOne(ctx, m)
+	return err
+}
+
+func (l *mongoLedger) forget(ctx context.Context, version int) error {
+	_, err := l.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: version}})
+	return err
+}
+
+func (l *mongoLedger) lock(ctx context.Context, owner string, at time.Time) error {
+	_, err := l.collection.InsertOne(ctx, bson.D{{Key: "_id", Value: lockID}, {Key: "owner", Value: owner}, {Key: "locked_at", Value: at}})
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrLocked
+	}
+	return err
+}
+
+func (l *mongoLedger) unlockBefore(ctx context.Context, at time.Time) (bool, error) {
+	res, err := l.collection.DeleteOne(ctx, bson.D{
+		{Key: "_id", Value: lockID},
+		{Key: "locked_at", Value: bson.D{{Key: "$lt", Value: at}}},
+	})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+func (l *mongoLedger) unlock(ctx context.Context, owner string) error {
+	_, err := l.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: lockID}, {Key: "owner", Value: owner}})
+	return err
+}
+
+func (l *mongoLedger) fresh(ctx context.Context) (bool, error) {
+	names, err := l.db.ListCollectionNames(ctx, bson.D{{Key: "name", Value: bson.D{{Key: "$ne", Value: Collection}}}})
+	if err != nil {
+		return false, err
+	}
+	// collections may exist without documents, e.g. when only their indexes were created
+	for _, name := range names {
+		n, err := l.db.Collection(name).CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+		if err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
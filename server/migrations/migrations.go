@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Migration changes the shape of the documents stored in MongoDB. Down undoes what Up did, both must be safe to
+// run again after failing halfway.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+	Down    func(ctx context.Context, db *mongo.Database) error
+}
+
+// All lists every migration in version order. Versions are never reused or reordered once released, new
+// migrations go at the end with the next version.
+var All = []Migration{
+	{Version: 1, Name: "store movie genres under genre", Up: movieGenreFieldUp, Down: movieGenreFieldDown},
+}
+
+// Latest is the version of the last migration, the shape of the documents this build reads and writes
+func Latest() int {
+	if len(All) == 0 {
+		return 0
+	}
+	return All[len(All)-1].Version
+}
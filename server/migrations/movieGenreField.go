@@ -0,0 +1,25 @@
+package migrations
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Movie.Genres used to have no bson tag, so the driver stored it as "genres" while every query and index uses
+// "genre"
+func movieGenreFieldUp(ctx context.Context, db *mongo.Database) error {
+	return renameField(ctx, db.Collection("movies"), "genres", "genre")
+}
+
+func movieGenreFieldDown(ctx context.Context, db *mongo.Database) error {
+	return renameField(ctx, db.Collection("movies"), "genre", "genres")
+}
+
+func renameField(ctx context.Context, collection *mongo.Collection, from, to string) error {
+	_, err := collection.UpdateMany(ctx,
+		bson.D{{Key: from, Value: bson.D{{Key: "$exists", Value: true}}}},
+		bson.D{{Key: "$rename", Value: bson.D{{Key: from, Value: to}}}},
+	)
+	return err
+}
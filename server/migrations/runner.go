@@ -0,0 +1,222 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"os"
+	"time"
+)
+
+const (
+	// Collection records the applied versions, one document per version, along with the lock
+	Collection = "migrations"
+	lockID     = "lock"
+	// a lock older than this is considered left behind by a crashed run
+	staleLock = 30 * time.Minute
+)
+
+var ErrLocked = errors.New("migrations are being run by another process")
+
+// Status is a migration and whether it is applied
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"` // nil while pending
+}
+
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// Runner applies and rolls back migrations, recording them in the migrations collection
+type Runner struct {
+	db         *mongo.Database
+	ledger     ledger
+	migrations []Migration
+}
+
+// NewRunner runs All against db
+func NewRunner(db *mongo.Database) *Runner {
+	return &Runner{db: db, ledger: &mongoLedger{db: db, collection: db.Collection(Collection)}, migrations: All}
+}
+
+// Baseline records every migration as applied, without running them, when the database is fresh: nothing was
+// migrated yet and it holds no documents, so they would have nothing to change. It tells whether it did.
+func (r *Runner) Baseline(ctx context.Context) (bool, error) {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	applied, err := r.applied(ctx)
+	if err != nil || len(applied) > 0 {
+		return false, err
+	}
+	fresh, err := r.ledger.fresh(ctx)
+	if err != nil || !fresh {
+		return false, err
+	}
+	now := time.Now()
+	for _, m := range r.migrations {
+		if err := r.ledger.record(ctx, appliedMigration{Version: m.Version, Name: m.Name, AppliedAt: now}); err != nil {
+			return false, err
+		}
+	}
+	return len(r.migrations) > 0, nil
+}
+
+// Status lists every known migration in version order
+func (r *Runner) Status(ctx context.Context) ([]Status, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(r.migrations))
+	for _, m := range r.migrations {
+		s := Status{Version: m.Version, Name: m.Name}
+		if a, ok := applied[m.Version]; ok {
+			s.AppliedAt = &a.AppliedAt
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations not applied yet, in version order
+func (r *Runner) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range r.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies the pending migrations up to version target (all of them when target is 0), in order, and returns
+// those it applied. It stops at the first failing one.
+func (r *Runner) Up(ctx context.Context, target int) ([]Migration, error) {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, m := range pending {
+		if target > 0 && m.Version > target {
+			break
+		}
+		if err := m.Up(ctx, r.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		record := appliedMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now()}
+		if err := r.ledger.record(ctx, record); err != nil {
+			return done, fmt.Errorf("migration %d (%s) applied but not recorded: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// Down rolls back the applied migrations above version target, latest first, and returns those it rolled back.
+// It stops at the first failing one.
+func (r *Runner) Down(ctx context.Context, target int) ([]Migration, error) {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	applied, err := r.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for i := len(r.migrations) - 1; i >= 0; i-- {
+		m := r.migrations[i]
+		if m.Version <= target {
+			break
+		}
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+		if err := m.Down(ctx, r.db); err != nil {
+			return done, fmt.Errorf("rolling back migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+		if err := r.ledger.forget(ctx, m.Version); err != nil {
+			return done, fmt.Errorf("migration %d (%s) rolled back but still recorded: %w", m.Version, m.Name, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// applied returns the recorded migrations by version. A version this build doesn't know means the database was
+// migrated by a newer build, running older migrations against it isn't safe.
+func (r *Runner) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	for i, m := range r.migrations {
+		if i > 0 && m.Version <= r.migrations[i-1].Version {
+			return nil, fmt.Errorf("migration %d (%s) is out of order", m.Version, m.Name)
+		}
+	}
+
+	records, err := r.ledger.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(r.migrations))
+	for _, m := range r.migrations {
+		known[m.Version] = true
+	}
+	applied := make(map[int]appliedMigration, len(records))
+	for _, a := range records {
+		if !known[a.Version] {
+			return nil, fmt.Errorf("the database has migration %d (%s) applied, which this build doesn't know", a.Version, a.Name)
+		}
+		applied[a.Version] = a
+	}
+	return applied, nil
+}
+
+// lock makes sure a single process migrates at a time, several instances may start together
+func (r *Runner) lock(ctx context.Context) (func(), error) {
+	owner, _ := os.Hostname()
+	owner = fmt.Sprintf("%s:%d", owner, os.Getpid())
+
+	err := r.ledger.lock(ctx, owner, time.Now())
+	if errors.Is(err, ErrLocked) {
+		// take over a lock left behind by a crashed run
+		stale, unlockErr := r.ledger.unlockBefore(ctx, time.Now().Add(-staleLock))
+		if unlockErr != nil {
+			return nil, unlockErr
+		}
+		if !stale {
+			return nil, ErrLocked
+		}
+		err = r.ledger.lock(ctx, owner, time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		unlockCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		r.ledger.unlock(unlockCtx, owner)
+	}, nil
+}
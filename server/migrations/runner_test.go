@@ -0,0 +1,315 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"maps"
+	"reflect"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryLedger stands in for the migrations collection
+type memoryLedger struct {
+	mu        sync.Mutex
+	records   map[int]appliedMigration
+	owner     string
+	lockedAt  time.Time
+	documents int // documents outside the bookkeeping
+}
+
+func newMemoryLedger() *memoryLedger {
+	return &memoryLedger{records: map[int]appliedMigration{}}
+}
+
+func (l *memoryLedger) applied(ctx context.Context) ([]appliedMigration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Collect(maps.Values(l.records)), nil
+}
+
+func (l *memoryLedger) record(ctx context.Context, m appliedMigration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.records[m.Version] = m
+	return nil
+}
+
+func (l *memoryLedger) forget(ctx context.Context, version int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.records, version)
+	return nil
+}
+
+func (l *memoryLedger) lock(ctx context.Context, owner string, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner != "" {
+		return ErrLocked
+	}
+	l.owner, l.lockedAt = owner, at
+	return nil
+}
+
+func (l *memoryLedger) unlockBefore(ctx context.Context, at time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner == "" || !l.lockedAt.Before(at) {
+		return false, nil
+	}
+	l.owner = ""
+	return true, nil
+}
+
+func (l *memoryLedger) unlock(ctx context.Context, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.owner == owner {
+		l.owner = ""
+	}
+	return nil
+}
+
+func (l *memoryLedger) fresh(ctx context.Context) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.documents == 0, nil
+}
+
+func (l *memoryLedger) versions() []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Sorted(maps.Keys(l.records))
+}
+
+// journal notes the steps the test migrations take
+type journal struct {
+	steps []string
+}
+
+func (j *journal) migration(version int, name string, failUp error) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up: func(ctx context.Context, db *mongo.Database) error {
+			if failUp != nil {
+				return failUp
+			}
+			j.steps = append(j.steps, "up "+name)
+			return nil
+		},
+		Down: func(ctx context.Context, db *mongo.Database) error {
+			j.steps = append(j.steps, "down "+name)
+			return nil
+		},
+	}
+}
+
+func newTestRunner(migrations ...Migration) (*Runner, *memoryLedger) {
+	l := newMemoryLedger()
+	return &Runner{ledger: l, migrations: migrations}, l
+}
+
+func versionsOf(migrations []Migration) []int {
+	versions := []int{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func TestUpAndDown(t *testing.T) {
+	j := &journal{}
+	r, l := newTestRunner(j.migration(1, "a", nil), j.migration(2, "b", nil), j.migration(5, "c", nil))
+	ctx := context.Background()
+
+	done, err := r.Up(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versionsOf(done); !reflect.DeepEqual(got, []int{1, 2}) {
+		t.Errorf("Up(2) applied %v, want [1 2]", got)
+	}
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versionsOf(pending); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("pending = %v, want [5]", got)
+	}
+
+	if done, err = r.Up(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := versionsOf(done); !reflect.DeepEqual(got, []int{5}) {
+		t.Errorf("Up(0) applied %v, want [5]", got)
+	}
+	if done, err = r.Up(ctx, 0); err != nil || len(done) != 0 {
+		t.Errorf("Up with nothing pending = %v, %v, want nothing", versionsOf(done), err)
+	}
+
+	if done, err = r.Down(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := versionsOf(done); !reflect.DeepEqual(got, []int{5, 2}) {
+		t.Errorf("Down(1) rolled back %v, want [5 2]", got)
+	}
+	if got := l.versions(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("recorded %v, want [1]", got)
+	}
+
+	statuses, err := r.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied := []bool{}
+	for _, s := range statuses {
+		applied = append(applied, s.AppliedAt != nil)
+	}
+	if !reflect.DeepEqual(applied, []bool{true, false, false}) {
+		t.Errorf("applied = %v, want [true false false]", applied)
+	}
+
+	want := []string{"up a", "up b", "up c", "down c", "down b"}
+	if !reflect.DeepEqual(j.steps, want) {
+		t.Errorf("steps = %q, want %q", j.steps, want)
+	}
+}
+
+func TestUpStopsAtFailure(t *testing.T) {
+	j := &journal{}
+	broken := errors.New("broken")
+	r, l := newTestRunner(j.migration(1, "a", nil), j.migration(2, "b", broken), j.migration(3, "c", nil))
+
+	done, err := r.Up(context.Background(), 0)
+	if !errors.Is(err, broken) {
+		t.Errorf("Up = %v, want %v", err, broken)
+	}
+	if got := versionsOf(done); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("Up applied %v, want [1]", got)
+	}
+	if got := l.versions(); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("recorded %v, want [1]", got)
+	}
+	if l.owner != "" {
+		t.Error("the lock wasn't released")
+	}
+}
+
+func TestOutOfOrderVersions(t *testing.T) {
+	j := &journal{}
+	for _, versions := range [][]int{{2, 1}, {1, 1}} {
+		r, _ := newTestRunner(j.migration(versions[0], "a", nil), j.migration(versions[1], "b", nil))
+		if _, err := r.Up(context.Background(), 0); err == nil {
+			t.Errorf("versions %v were run", versions)
+		}
+	}
+	if len(j.steps) != 0 {
+		t.Errorf("steps = %q, want none", j.steps)
+	}
+}
+
+func TestUnknownAppliedVersion(t *testing.T) {
+	j := &journal{}
+	r, l := newTestRunner(j.migration(1, "a", nil))
+	l.records[7] = appliedMigration{Version: 7, Name: "from a newer build", AppliedAt: time.Now()}
+
+	if _, err := r.Pending(context.Background()); err == nil {
+		t.Error("Pending accepted a version this build doesn't know")
+	}
+	if _, err := r.Up(context.Background(), 0); err == nil {
+		t.Error("Up ran against a database migrated by a newer build")
+	}
+	if _, err := r.Down(context.Background(), 0); err == nil {
+		t.Error("Down ran against a database migrated by a newer build")
+	}
+	if len(j.steps) != 0 {
+		t.Errorf("steps = %q, want none", j.steps)
+	}
+}
+
+func TestLock(t *testing.T) {
+	j := &journal{}
+	r, l := newTestRunner(j.migration(1, "a", nil))
+	ctx := context.Background()
+
+	l.owner, l.lockedAt = "other:1", time.Now().Add(-time.Minute)
+	if _, err := r.Up(ctx, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Up while another run holds the lock = %v, want ErrLocked", err)
+	}
+	if _, err := r.Baseline(ctx); !errors.Is(err, ErrLocked) {
+		t.Errorf("Baseline while another run holds the lock = %v, want ErrLocked", err)
+	}
+	if l.owner != "other:1" {
+		t.Errorf("the lock of the other run was taken over by %q", l.owner)
+	}
+
+	// a run that crashed long ago
+	l.lockedAt = time.Now().Add(-staleLock - time.Minute)
+	if _, err := r.Up(ctx, 0); err != nil {
+		t.Errorf("Up with a stale lock = %v", err)
+	}
+	if l.owner != "" {
+		t.Errorf("lock still held by %q", l.owner)
+	}
+	if !reflect.DeepEqual(j.steps, []string{"up a"}) {
+		t.Errorf("steps = %q, want [up a]", j.steps)
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name      string
+		documents int
+		applied   []int
+		want      bool
+		recorded  []int
+	}{
+		{"fresh database", 0, nil, true, []int{1, 2}},
+		{"database with documents", 3, nil, false, nil},
+		{"database migrated before", 0, []int{1}, false, []int{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := &journal{}
+			r, l := newTestRunner(j.migration(1, "a", nil), j.migration(2, "b", nil))
+			l.documents = tt.documents
+			for _, v := range tt.applied {
+				l.records[v] = appliedMigration{Version: v, AppliedAt: time.Now()}
+			}
+
+			got, err := r.Baseline(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Baseline = %v, want %v", got, tt.want)
+			}
+			if got := l.versions(); !reflect.DeepEqual(got, tt.recorded) {
+				t.Errorf("recorded %v, want %v", got, tt.recorded)
+			}
+			if len(j.steps) != 0 {
+				t.Errorf("Baseline ran %q", j.steps)
+			}
+		})
+	}
+}
+
+func TestAllInOrder(t *testing.T) {
+	for i, m := range All {
+		if m.Up == nil || m.Down == nil || m.Name == "" {
+			t.Errorf("migration %d is incomplete", m.Version)
+		}
+		if i > 0 && m.Version <= All[i-1].Version {
+			t.Errorf("migration %d is out of order", m.Version)
+		}
+	}
+	if Latest() != All[len(All)-1].Version {
+		t.Errorf("Latest() = %d, want %d", Latest(), All[len(All)-1].Version)
+	}
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+const (
+	HLSStatusProcessing = "processing"
+	HLSStatusReady      = "ready"
+	HLSStatusFailed     = "failed"
+)
+
+// HLSPackage describes the adaptive streaming renditions of a movie
+type HLSPackage struct {
+	Status     string      `bson:"status" json:"status"`
+	SourceKey  string      `bson:"source_key" json:"source_key"`
+	Renditions []Rendition `bson:"renditions" json:"renditions"`
+	Error      string      `bson:"error,omitempty" json:"error,omitempty"`
+	UpdatedAt  time.Time   `bson:"updated_at" json:"updated_at"`
+}
+
+// Rendition is one variant stream, its playlist and segments are stored under <imdb_id>/hls/<name>/
+type Rendition struct {
+	Name      string `bson:"name" json:"name"`
+	Width     int    `bson:"width" json:"width"`
+	Height    int    `bson:"height" json:"height"`
+	Bandwidth int    `bson:"bandwidth" json:"bandwidth"` // bits per second, video and audio
+	Codecs    string `bson:"codecs" json:"codecs"`
+}
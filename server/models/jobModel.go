@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// Job is a unit of background work, e.g. packaging a movie for HLS. Payload holds the arguments of its Type.
+type Job struct {
+	ID              string            `bson:"_id" json:"id"`
+	Type            string            `bson:"type" json:"type"`
+	Payload         map[string]string `bson:"payload" json:"payload"`
+	Status          string            `bson:"status" json:"status"`
+	Attempts        int               `bson:"attempts" json:"attempts"`
+	MaxAttempts     int               `bson:"max_attempts" json:"max_attempts"`
+	LastError       string            `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	CancelRequested bool              `bson:"cancel_requested" json:"cancel_requested"`
+	RunAt           time.Time         `bson:"run_at" json:"run_at"`     // not before, pushed back between retries
+	LockedUntil     time.Time         `bson:"locked_until" json:"-"`    // lease of the worker running it
+	Lease           string            `bson:"lease,omitempty" json:"-"` // token of the claim holding the lease
+	CreatedBy       string            `bson:"created_by,omitempty" json:"created_by,omitempty"`
+	CreatedAt       time.Time         `bson:"created_at" json:"created_at"`
+	UpdatedAt       time.Time         `bson:"updated_at" json:"updated_at"`
+	FinishedAt      *time.Time        `bson:"finished_at,omitempty" json:"finished_at,omitempty"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MediaFile is a video stored in the blob store and playable through the stream endpoint
+type MediaFile struct {
+	Key         string    `bson:"key" json:"key" validate:"required"`
+	Label       string    `bson:"label,omitempty" json:"label,omitempty"` // e.g. "1080p", picks the file to stream
+	ContentType string    `bson:"content_type" json:"content_type"`
+	Size        int64     `bson:"size" json:"size"`
+	SHA256      string    `bson:"sha256,omitempty" json:"sha256,omitempty"`
+	CreatedAt   time.Time `bson:"created_at" json:"created_at"`
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
 )
 
 // Converting a Go type to BSON is called marshalling, while the reverse process is called unmarshalling
@@ -12,13 +13,21 @@ type Movie struct {
 	Title       string        `bson:"title" json:"title" validate:"required,min=2"`
 	PosterPath  string        `bson:"poster_path" json:"poster_path" validate:"required,url"`
 	YoutubeId   string        `bson:"youtube_id" json:"youtube_id" validate:"required"`
-	Genres      []Genre       `json:"genre" validate"dive,required"`
-	AdminReview string        `bson:"admin_review" json:"admin_review" validate"max=128"`
-	Rating     int       `bson:"rating" json:"ranking" validate:"min=1,max=10"`
+	Genres      []Genre       `bson:"genre" json:"genre" validate:"dive,required"`
+	AdminReview string        `bson:"admin_review" json:"admin_review" validate:"max=128"`
+	Rating      int           `bson:"rating" json:"ranking" validate:"min=1,max=10"`
+	Media       []MediaFile   `bson:"media,omitempty" json:"media,omitempty" validate:"dive"`
+	HLS         *HLSPackage   `bson:"hls,omitempty" json:"hls,omitempty"`
+	// Community is kept up to date from the reviews of users, Rating is the admin's
+	Community *CommunityScore `bson:"community_score,omitempty" json:"community_score,omitempty"`
+	// DeletedAt is set on retired movies, they are hidden until an admin restores them
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// Version counts the updates of the catalogue fields, a merge patch only applies to the version it was made from
+	Version int `bson:"version" json:"version" validate:"min=0"`
 }
 
 type Genre struct {
 	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
-	GenreID   int           `bson:"genre_id" json"genre_id" validate:"required"`
+	GenreID   int           `bson:"genre_id" json:"genre_id" validate:"required"`
 	GenreName string        `bson:"genre_name" json:"genre_name" validate:"required,max=64"`
 }
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// Review is the rating of a movie by a user, with an optional text. A user reviews a movie once.
+type Review struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	ImdbID    string        `bson:"imdb_id" json:"imdb_id"`
+	UserEmail string        `bson:"user_email" json:"-"`
+	Author    string        `bson:"author" json:"author"` // display name, e.g. "Jane D."
+	Rating    int           `bson:"rating" json:"rating" validate:"min=1,max=10"`
+	Text      string        `bson:"text,omitempty" json:"text,omitempty" validate:"max=2000"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+}
+
+// CommunityScore sums up the user ratings of a movie
+type CommunityScore struct {
+	Average float64 `bson:"average" json:"average"`
+	Count   int     `bson:"count" json:"count"`
+	// Distribution counts the ratings given, Distribution[0] is the number of 1s and Distribution[9] of 10s
+	Distribution [10]int `bson:"distribution" json:"distribution"`
+}
@@ -0,0 +1,35 @@
+package models
+
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+type Permission string
+
+const (
+	PermCatalogueRead  Permission = "catalogue:read"
+	PermCatalogueWrite Permission = "catalogue:write"
+	PermUsersManage    Permission = "users:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleViewer: {PermCatalogueRead},
+	RoleEditor: {PermCatalogueRead, PermCatalogueWrite},
+	RoleAdmin:  {PermCatalogueRead, PermCatalogueWrite, PermUsersManage},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func HasPermission(role string, perm Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
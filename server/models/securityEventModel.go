@@ -0,0 +1,24 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+const (
+	// a refresh token was presented again after being rotated, its whole family got revoked
+	SecurityEventRefreshReuse = "refresh_token_reuse"
+	// a refresh token was presented again within the grace window of its rotation by another IP or user agent than
+	// the one that rotated it; answered like a concurrent refresh, the family is kept
+	SecurityEventRefreshGraceReuse = "refresh_token_grace_reuse"
+)
+
+type SecurityEvent struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserEmail string        `bson:"user_email" json:"user_email"`
+	Type      string        `bson:"type" json:"type"`
+	Family    string        `bson:"family,omitempty" json:"family,omitempty"`
+	IP        string        `bson:"ip" json:"ip"`
+	UserAgent string        `bson:"user_agent" json:"user_agent"`
+	CreatedAt time.Time     `bson:"created_at" json:"created_at"`
+}
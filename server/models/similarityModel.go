@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// MovieSimilarity lists the movies most similar to a movie according to how users rated and watched them (the
+// movie_similarities collection). It is computed by the recommendation batch job.
+type MovieSimilarity struct {
+	ImdbID     string         `bson:"imdb_id" json:"imdb_id"`
+	Neighbors  []SimilarMovie `bson:"neighbors" json:"neighbors"` // most similar first
+	ComputedAt time.Time      `bson:"computed_at" json:"computed_at"`
+}
+
+type SimilarMovie struct {
+	ImdbID string  `bson:"imdb_id" json:"imdb_id"`
+	Score  float64 `bson:"score" json:"score"` // between 0 and 1
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Upload is a resumable upload in progress. Once all Length bytes are received the file is moved to the blob
+// store and attached to the movie, Media then describes it.
+type Upload struct {
+	ID        string     `json:"id"`
+	ImdbID    string     `json:"imdb_id"`
+	Filename  string     `json:"filename"`
+	Label     string     `json:"label,omitempty"`
+	Length    int64      `json:"length"`
+	Offset    int64      `json:"offset"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	Media     *MediaFile `json:"media,omitempty"`
+}
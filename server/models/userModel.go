@@ -5,16 +5,23 @@ import (
 	"time"
 )
 
+const (
+	UserStatusPending = "pending" // waiting for the email address to be verified
+	UserStatusActive  = "active"
+)
+
 type User struct {
-	ID              bson.ObjectID `bson:"_id,omitempty"`
-	FirstName       string        `bson:"first_name" json:"first_name" validate:"required"`
-	LastName        string        `bson:"last_name" json:"last_name" validate:"required"`
-	Email           string        `bson:"email" json:"email" validate:"required,email"`
-	Password        string        `bson:"password" json:"password" validate:"required,min=8"`
-	Role            string        `bson:"role" json:"role" validate:"required"`
-	CreatedAt       time.Time     `bson:"created_at" json:"created_at" validate:"required"`
-	UpdatedAt       time.Time     `bson:"updated_at" json:"updated_at" validate:"required"`
-	FavouriteGenres []Genre       `bson:"favourite_genres" json:"favourite_genres" validate:"dive,required"`
+	ID        bson.ObjectID `bson:"_id,omitempty"`
+	FirstName string        `bson:"first_name" json:"first_name" validate:"required"`
+	LastName  string        `bson:"last_name" json:"last_name" validate:"required"`
+	Email     string        `bson:"email" json:"email" validate:"required,email"`
+	Password  string        `bson:"password" json:"password" validate:"required,min=8"`
+	Role      string        `bson:"role" json:"role" validate:"required,oneof=admin editor viewer"`
+	// Status is empty for accounts created before email verification existed, they count as active
+	Status          string    `bson:"status,omitempty" json:"status"`
+	CreatedAt       time.Time `bson:"created_at" json:"created_at" validate:"required"`
+	UpdatedAt       time.Time `bson:"updated_at" json:"updated_at" validate:"required"`
+	FavouriteGenres []Genre   `bson:"favourite_genres" json:"favourite_genres" validate:"dive,required"`
 }
 
 // Data Transfer Object
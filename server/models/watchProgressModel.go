@@ -0,0 +1,20 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// a title counts as watched once this share of it was played, skipping the end credits doesn't leave it unfinished
+const FinishedRatio = 0.95
+
+// WatchProgress is where a user stopped in a movie, one per user and movie (the watch_history collection)
+type WatchProgress struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserEmail string        `bson:"user_email" json:"-"`
+	ImdbID    string        `bson:"imdb_id" json:"imdb_id"`
+	Position  float64       `bson:"position" json:"position"` // seconds
+	Duration  float64       `bson:"duration" json:"duration"` // seconds
+	Finished  bool          `bson:"finished" json:"finished"`
+	UpdatedAt time.Time     `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"time"
+)
+
+// WatchlistItem is a movie a user saved for later, Position orders the watchlist
+type WatchlistItem struct {
+	ID        bson.ObjectID `bson:"_id,omitempty" json:"-"`
+	UserEmail string        `bson:"user_email" json:"-"`
+	ImdbID    string        `bson:"imdb_id" json:"imdb_id"`
+	Position  int           `bson:"position" json:"position"`
+	AddedAt   time.Time     `bson:"added_at" json:"added_at"`
+}
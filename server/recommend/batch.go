@@ -0,0 +1,58 @@
+package recommend
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"log"
+	"os"
+	"time"
+)
+
+// Batch recomputes the movie similarities from every rating and watch history entry
+type Batch struct {
+	reviews      repository.ReviewRepository
+	history      repository.WatchHistoryRepository
+	similarities repository.SimilarityRepository
+	interval     time.Duration
+}
+
+// NewBatch runs every RECOMMENDATION_INTERVAL (default 1h)
+func NewBatch(reviews repository.ReviewRepository, history repository.WatchHistoryRepository, similarities repository.SimilarityRepository) *Batch {
+	interval := time.Hour
+	if d, err := time.ParseDuration(os.Getenv("RECOMMENDATION_INTERVAL")); err == nil && d > 0 {
+		interval = d
+	}
+	return &Batch{reviews: reviews, history: history, similarities: similarities, interval: interval}
+}
+
+// Run computes the similarities right away, then every interval until ctx is done. Instances sharing a database
+// compute the same result, whichever writes last wins.
+func (b *Batch) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		if err := b.Compute(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("computing movie similarities failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *Batch) Compute(ctx context.Context) error {
+	started := time.Now()
+
+	reviews, err := b.reviews.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	history, err := b.history.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	return b.similarities.ReplaceAll(ctx, ComputeSimilarities(BuildPreferences(reviews, history)), started)
+}
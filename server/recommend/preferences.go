@@ -0,0 +1,44 @@
+package recommend
+
+import "github.com/ImranullahKhann/movie-streaming-app/server/models"
+
+// Weights of viewing signals, a preference ranges from -1 (disliked) to 1 (loved)
+const (
+	finishedWeight = 0.6 // watched to the end without rating it
+	startedWeight  = 0.2 // started, still in progress or abandoned
+)
+
+// Preferences maps users (by email) to how much they like the movies (by imdb_id) they rated or watched
+type Preferences map[string]map[string]float64
+
+// RatingWeight maps a 1 to 10 rating to a preference, 5.5 being neutral
+func RatingWeight(rating int) float64 {
+	return (float64(rating) - 5.5) / 4.5
+}
+
+func progressWeight(p models.WatchProgress) float64 {
+	if p.Finished {
+		return finishedWeight
+	}
+	return startedWeight
+}
+
+// BuildPreferences combines ratings and watch history. A rating is explicit and overrides the viewing signal of the
+// same movie.
+func BuildPreferences(reviews []models.Review, history []models.WatchProgress) Preferences {
+	prefs := Preferences{}
+	user := func(email string) map[string]float64 {
+		if prefs[email] == nil {
+			prefs[email] = map[string]float64{}
+		}
+		return prefs[email]
+	}
+
+	for _, p := range history {
+		user(p.UserEmail)[p.ImdbID] = progressWeight(p)
+	}
+	for _, r := range reviews {
+		user(r.UserEmail)[r.ImdbID] = RatingWeight(r.Rating)
+	}
+	return prefs
+}
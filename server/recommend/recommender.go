@@ -0,0 +1,339 @@
+package recommend
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/repository"
+	"slices"
+)
+
+const (
+	// number of liked movies at which collaborative filtering and genre affinity weigh the same, users with fewer
+	// rely mostly on their genres
+	coldStartLikes = 5
+	// keeps a movie similar to a single liked one from outranking movies similar to several
+	collaborativeShrink = 1.0
+	// share of the score coming from the admin rating, it mostly breaks ties
+	ratingShare = 0.1
+	// candidates fetched from each source for every recommendation asked for
+	candidatesPerResult = 3
+)
+
+// Reason kinds
+const (
+	ReasonSimilar        = "similar"         // similar to a movie the user rated or watched
+	ReasonFavouriteGenre = "favourite_genre" // in one of the favourite genres of the user
+	ReasonLikedGenre     = "liked_genre"     // in a genre of movies the user liked
+)
+
+// Why a candidate was not recommended
+const (
+	RejectedSeen        = "already_seen" // the user rated or started watching it
+	RejectedNotRelevant = "not_relevant" // nothing the user likes points to it
+	RejectedBelowLimit  = "below_limit"  // ranked past the number of recommendations asked for
+	RejectedCutoff      = "cutoff"       // one of too many similar movies, never loaded
+)
+
+// Reason is the main thing a movie was recommended for
+type Reason struct {
+	Kind   string `json:"kind"`
+	Text   string `json:"text"`              // e.g. "Because you watched Inception"
+	ImdbID string `json:"imdb_id,omitempty"` // the movie behind a ReasonSimilar
+	Genre  string `json:"genre,omitempty"`   // the genre behind a ReasonFavouriteGenre or ReasonLikedGenre
+}
+
+// Breakdown details how a score is made up:
+// Score = (1-RatingShare) * (CollaborativeShare*Collaborative + (1-CollaborativeShare)*Genre) + RatingShare*Rating
+type Breakdown struct {
+	Collaborative      float64 `json:"collaborative"` // predicted preference from similar movies, -1 to 1
+	CollaborativeShare float64 `json:"collaborative_share"`
+	Genre              float64 `json:"genre"`  // affinity of the user for the genres of the movie, 0 to 1
+	Rating             float64 `json:"rating"` // admin rating scaled to 0 to 1
+	RatingShare        float64 `json:"rating_share"`
+}
+
+// Recommendation is a movie along with why and how much it is recommended. The movie fields are inlined so
+// clients reading recommendations as plain movies keep working.
+type Recommendation struct {
+	models.Movie
+	Score     float64   `json:"score"`
+	Reason    *Reason   `json:"reason,omitempty"`
+	Breakdown Breakdown `json:"score_breakdown"`
+}
+
+// Candidate is a movie considered for a user, Rejected tells why it was left out
+type Candidate struct {
+	Recommendation
+	Sources  []string `json:"sources"` // "similar" and/or "genre"
+	Rejected string   `json:"rejected,omitempty"`
+}
+
+// Explanation is everything a recommendation run is based on, for debugging
+type Explanation struct {
+	Recommendations    []Recommendation   `json:"-"` // best first
+	Seen               []string           `json:"seen"`
+	Likes              int                `json:"likes"`
+	CollaborativeShare float64            `json:"collaborative_share"`
+	GenreAffinity      map[string]float64 `json:"genre_affinity"`
+	// Candidates holds every candidate, recommended or not, best first
+	Candidates []Candidate `json:"candidates"`
+}
+
+// Recommender ranks the movies a user hasn't watched yet by blending the similarities computed by Batch with the
+// genres the user likes
+type Recommender struct {
+	movies       repository.MovieRepository
+	reviews      repository.ReviewRepository
+	history      repository.WatchHistoryRepository
+	similarities repository.SimilarityRepository
+}
+
+func NewRecommender(movies repository.MovieRepository, reviews repository.ReviewRepository, history repository.WatchHistoryRepository, similarities repository.SimilarityRepository) *Recommender {
+	return &Recommender{movies: movies, reviews: reviews, history: history, similarities: similarities}
+}
+
+// similarity is the collaborative score of a candidate, because is the seen movie contributing the most to it
+type similarity struct {
+	imdbID  string
+	score   float64
+	because string
+}
+
+// Explain recommends up to limit movies for user, best first, along with what they are based on. Movies the user
+// rated or started watching are left out.
+func (r *Recommender) Explain(ctx context.Context, user *models.User, limit int) (*Explanation, error) {
+	reviews, err := r.reviews.FindByUser(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	history, err := r.history.FindByUser(ctx, user.Email)
+	if err != nil {
+		return nil, err
+	}
+	prefs := BuildPreferences(reviews, history)[user.Email]
+	ratings := make(map[string]int, len(reviews))
+	for _, rv := range reviews {
+		ratings[rv.ImdbID] = rv.Rating
+	}
+
+	e := &Explanation{Seen: make([]string, 0, len(prefs))}
+	for id, w := range prefs {
+		e.Seen = append(e.Seen, id)
+		if w > 0 {
+			e.Likes++
+		}
+	}
+	slices.Sort(e.Seen)
+	// the more the user liked, the more collaborative filtering is trusted
+	e.CollaborativeShare = float64(e.Likes) / float64(e.Likes+coldStartLikes)
+
+	seenMovies, err := r.movies.FindByImdbIDs(ctx, e.Seen)
+	if err != nil {
+		return nil, err
+	}
+	titles := make(map[string]string, len(seenMovies))
+	for _, m := range seenMovies {
+		titles[m.ImdbID] = m.Title
+	}
+
+	collaborative, cutoff, err := r.collaborativeScores(ctx, prefs, e.Seen, limit*candidatesPerResult)
+	if err != nil {
+		return nil, err
+	}
+	e.GenreAffinity = genreAffinity(user, prefs, seenMovies)
+
+	// the collaborative candidates, then the best rated movies of the liked genres
+	candidateIDs := make([]string, 0, len(collaborative))
+	for id := range collaborative {
+		candidateIDs = append(candidateIDs, id)
+	}
+	candidates, err := r.movies.FindByImdbIDs(ctx, candidateIDs)
+	if err != nil {
+		return nil, err
+	}
+	sources := map[string][]string{}
+	for _, m := range candidates {
+		sources[m.ImdbID] = []string{"similar"}
+	}
+	genreNames := make([]string, 0, len(e.GenreAffinity))
+	for name, a := range e.GenreAffinity {
+		if a > 0 {
+			genreNames = append(genreNames, name)
+		}
+	}
+	if len(genreNames) > 0 {
+		byGenre, err := r.movies.FindRecommended(ctx, genreNames, int64(limit*candidatesPerResult+len(e.Seen)))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range byGenre {
+			if sources[m.ImdbID] == nil {
+				candidates = append(candidates, m)
+			}
+			sources[m.ImdbID] = append(sources[m.ImdbID], "genre")
+		}
+	}
+
+	favourite := make(map[string]bool, len(user.FavouriteGenres))
+	for _, g := range user.FavouriteGenres {
+		favourite[g.GenreName] = true
+	}
+
+	for _, m := range candidates {
+		c := Candidate{Sources: sources[m.ImdbID]}
+		c.Movie = m
+		c.Breakdown = Breakdown{
+			Collaborative:      collaborative[m.ImdbID].score,
+			CollaborativeShare: e.CollaborativeShare,
+			Rating:             float64(m.Rating) / 10,
+			RatingShare:        ratingShare,
+		}
+		var genre string
+		c.Breakdown.Genre, genre = genreScore(m, e.GenreAffinity)
+
+		collaborativePart := e.CollaborativeShare * c.Breakdown.Collaborative
+		genrePart := (1 - e.CollaborativeShare) * c.Breakdown.Genre
+		relevance := collaborativePart + genrePart
+		c.Score = (1-ratingShare)*relevance + ratingShare*c.Breakdown.Rating
+
+		switch {
+		case collaborativePart > 0 && collaborativePart >= genrePart:
+			because := collaborative[m.ImdbID].because
+			text := "Because you watched " + titles[because]
+			if rating, ok := ratings[because]; ok {
+				text = fmt.Sprintf("Because you rated %s %d/10", titles[because], rating)
+			}
+			c.Reason = &Reason{Kind: ReasonSimilar, Text: text, ImdbID: because}
+		case genrePart > 0 && favourite[genre]:
+			c.Reason = &Reason{Kind: ReasonFavouriteGenre, Text: "Matches your favourite genre " + genre, Genre: genre}
+		case genrePart > 0:
+			c.Reason = &Reason{Kind: ReasonLikedGenre, Text: "Because you like " + genre + " movies", Genre: genre}
+		}
+
+		if _, ok := prefs[m.ImdbID]; ok {
+			c.Rejected = RejectedSeen
+		} else if relevance <= 0 {
+			c.Rejected = RejectedNotRelevant
+		}
+		e.Candidates = append(e.Candidates, c)
+	}
+
+	slices.SortFunc(e.Candidates, func(a, b Candidate) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.ImdbID, b.ImdbID)
+	})
+	e.Recommendations = []Recommendation{}
+	for i := range e.Candidates {
+		c := &e.Candidates[i]
+		if c.Rejected != "" {
+			continue
+		}
+		if len(e.Recommendations) == limit {
+			c.Rejected = RejectedBelowLimit
+			continue
+		}
+		e.Recommendations = append(e.Recommendations, c.Recommendation)
+	}
+
+	// movies cut before loading only carry their collaborative score
+	for _, s := range cutoff {
+		c := Candidate{Sources: []string{"similar"}, Rejected: RejectedCutoff}
+		c.ImdbID = s.imdbID
+		c.Breakdown = Breakdown{Collaborative: s.score, CollaborativeShare: e.CollaborativeShare, RatingShare: ratingShare}
+		e.Candidates = append(e.Candidates, c)
+	}
+	return e, nil
+}
+
+// collaborativeScores predicts the preference (-1 to 1) of the user for the movies similar to the ones they rated
+// or watched, keeping the best ones up to keep. The others are returned as cutoff, best first.
+func (r *Recommender) collaborativeScores(ctx context.Context, prefs map[string]float64, seen []string, keep int) (map[string]similarity, []similarity, error) {
+	similarities, err := r.similarities.FindByImdbIDs(ctx, seen)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// in imdb_id order, so ties on the movie behind a score are settled the same way every time
+	slices.SortFunc(similarities, func(a, b models.MovieSimilarity) int { return cmp.Compare(a.ImdbID, b.ImdbID) })
+
+	weighted := map[string]float64{}
+	total := map[string]float64{}
+	because := map[string]string{}
+	strongest := map[string]float64{}
+	for _, s := range similarities {
+		w := prefs[s.ImdbID]
+		for _, n := range s.Neighbors {
+			if _, ok := prefs[n.ImdbID]; ok {
+				continue
+			}
+			weighted[n.ImdbID] += n.Score * w
+			total[n.ImdbID] += n.Score
+			if _, ok := because[n.ImdbID]; !ok || n.Score*w > strongest[n.ImdbID] {
+				because[n.ImdbID] = s.ImdbID
+				strongest[n.ImdbID] = n.Score * w
+			}
+		}
+	}
+
+	ranked := make([]similarity, 0, len(weighted))
+	for id, w := range weighted {
+		if w > 0 {
+			ranked = append(ranked, similarity{imdbID: id, score: w / (total[id] + collaborativeShrink), because: because[id]})
+		}
+	}
+	slices.SortFunc(ranked, func(a, b similarity) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.imdbID, b.imdbID)
+	})
+
+	kept := ranked[:min(keep, len(ranked))]
+	scores := make(map[string]similarity, len(kept))
+	for _, s := range kept {
+		scores[s.imdbID] = s
+	}
+	return scores, ranked[len(kept):], nil
+}
+
+// genreAffinity scores genre names from 0 to 1. The favourite genres of the user count as much as a loved movie,
+// the genres of the movies they rated or watched as much as they liked them.
+func genreAffinity(user *models.User, prefs map[string]float64, seenMovies []models.Movie) map[string]float64 {
+	affinity := map[string]float64{}
+	for _, g := range user.FavouriteGenres {
+		affinity[g.GenreName] += 1
+	}
+	for _, m := range seenMovies {
+		for _, g := range m.Genres {
+			affinity[g.GenreName] += prefs[m.ImdbID]
+		}
+	}
+
+	top := 0.0
+	for _, a := range affinity {
+		top = max(top, a)
+	}
+	for name, a := range affinity {
+		if top > 0 {
+			affinity[name] = max(a, 0) / top
+		} else {
+			affinity[name] = 0
+		}
+	}
+	return affinity
+}
+
+// genreScore is the affinity of the user for the genre of the movie they like best, along with that genre
+func genreScore(m models.Movie, affinity map[string]float64) (float64, string) {
+	score, genre := 0.0, ""
+	for _, g := range m.Genres {
+		if a := affinity[g.GenreName]; a > score {
+			score, genre = a, g.GenreName
+		}
+	}
+	return score, genre
+}
@@ -0,0 +1,90 @@
+package recommend
+
+import (
+	"cmp"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"math"
+	"slices"
+)
+
+const (
+	// MaxNeighbors is the number of similar movies kept for each movie
+	MaxNeighbors = 50
+	// similarities backed by few users are shrunk towards 0, they count half when shrinkUsers users are behind them
+	shrinkUsers = 3
+	// the strongest preferences of prolific users are kept, the pairs of movies grow with the square of their number
+	maxMoviesPerUser = 500
+)
+
+type moviePair struct {
+	a, b string // a < b
+}
+
+// ComputeSimilarities computes the item-item cosine similarity of movies, each movie being the vector of the
+// preferences users have for it. Only positive similarities are kept, sorted by imdb_id.
+func ComputeSimilarities(prefs Preferences) []models.MovieSimilarity {
+	dots := map[moviePair]float64{}
+	common := map[moviePair]int{}
+	norms := map[string]float64{}
+
+	for _, movies := range prefs {
+		ids := strongest(movies, maxMoviesPerUser)
+		slices.Sort(ids)
+		for i, a := range ids {
+			wa := movies[a]
+			norms[a] += wa * wa
+			for _, b := range ids[i+1:] {
+				p := moviePair{a, b}
+				dots[p] += wa * movies[b]
+				common[p]++
+			}
+		}
+	}
+
+	neighbors := map[string][]models.SimilarMovie{}
+	for p, dot := range dots {
+		if dot <= 0 {
+			continue
+		}
+		n := float64(common[p])
+		score := dot / math.Sqrt(norms[p.a]*norms[p.b]) * n / (n + shrinkUsers)
+		neighbors[p.a] = append(neighbors[p.a], models.SimilarMovie{ImdbID: p.b, Score: score})
+		neighbors[p.b] = append(neighbors[p.b], models.SimilarMovie{ImdbID: p.a, Score: score})
+	}
+
+	similarities := make([]models.MovieSimilarity, 0, len(neighbors))
+	for id, ns := range neighbors {
+		slices.SortFunc(ns, func(x, y models.SimilarMovie) int {
+			if c := cmp.Compare(y.Score, x.Score); c != 0 {
+				return c
+			}
+			return cmp.Compare(x.ImdbID, y.ImdbID)
+		})
+		if len(ns) > MaxNeighbors {
+			ns = ns[:MaxNeighbors]
+		}
+		similarities = append(similarities, models.MovieSimilarity{ImdbID: id, Neighbors: ns})
+	}
+	slices.SortFunc(similarities, func(x, y models.MovieSimilarity) int { return cmp.Compare(x.ImdbID, y.ImdbID) })
+	return similarities
+}
+
+// strongest returns the ids of the (at most) n movies with the strongest preferences, liked or disliked
+func strongest(movies map[string]float64, n int) []string {
+	ids := make([]string, 0, len(movies))
+	for id, w := range movies {
+		if w != 0 {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) <= n {
+		return ids
+	}
+	slices.SortFunc(ids, func(a, b string) int {
+		if c := cmp.Compare(math.Abs(movies[b]), math.Abs(movies[a])); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return ids[:n]
+}
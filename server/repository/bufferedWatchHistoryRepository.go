@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// BufferedWatchHistoryRepository coalesces progress reports: the latest one of each user and movie is kept in
+// memory and written to the underlying repository every interval, so players reporting their position every few
+// seconds cost a write per interval at most. Reads see buffered progress right away.
+type BufferedWatchHistoryRepository struct {
+	next     WatchHistoryRepository
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending map[string]models.WatchProgress // by progressKey
+}
+
+func NewBufferedWatchHistoryRepository(next WatchHistoryRepository, interval time.Duration) *BufferedWatchHistoryRepository {
+	return &BufferedWatchHistoryRepository{next: next, interval: interval, pending: map[string]models.WatchProgress{}}
+}
+
+// Run flushes the buffer every interval until ctx is done, then one last time
+func (r *BufferedWatchHistoryRepository) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := r.Flush(flushCtx); err != nil {
+				log.Printf("saving watch progress failed: %v", err)
+			}
+			cancel()
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				log.Printf("saving watch progress failed: %v", err)
+			}
+		}
+	}
+}
+
+// Flush writes the buffered progress. Entries failing to save stay buffered unless a newer report replaced them.
+func (r *BufferedWatchHistoryRepository) Flush(ctx context.Context) error {
+	r.mu.Lock()
+	batch := r.pending
+	r.pending = map[string]models.WatchProgress{}
+	r.mu.Unlock()
+
+	var firstErr error
+	for key, progress := range batch {
+		if err := r.next.Save(ctx, progress); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			r.mu.Lock()
+			if _, replaced := r.pending[key]; !replaced {
+				r.pending[key] = progress
+			}
+			r.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
+func (r *BufferedWatchHistoryRepository) Save(ctx context.Context, progress models.WatchProgress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending[progressKey(progress.UserEmail, progress.ImdbID)] = progress
+	return nil
+}
+
+func (r *BufferedWatchHistoryRepository) Find(ctx context.Context, email, imdbID string) (*models.WatchProgress, error) {
+	r.mu.Lock()
+	progress, ok := r.pending[progressKey(email, imdbID)]
+	r.mu.Unlock()
+	if ok {
+		return &progress, nil
+	}
+	return r.next.Find(ctx, email, imdbID)
+}
+
+func (r *BufferedWatchHistoryRepository) FindByUser(ctx context.Context, email string) ([]models.WatchProgress, error) {
+	history, err := r.next.FindByUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	return r.merge(email, history, false, 0), nil
+}
+
+func (r *BufferedWatchHistoryRepository) FindInProgress(ctx context.Context, email string, limit int64) ([]models.WatchProgress, error) {
+	// buffered entries may push stored ones out of the page or drop out of it once finished, fetch enough to cover both
+	r.mu.Lock()
+	buffered := int64(0)
+	for _, p := range r.pending {
+		if p.UserEmail == email {
+			buffered++
+		}
+	}
+	r.mu.Unlock()
+
+	history, err := r.next.FindInProgress(ctx, email, limit+buffered)
+	if err != nil {
+		return nil, err
+	}
+	return r.merge(email, history, true, limit), nil
+}
+
+func (r *BufferedWatchHistoryRepository) FindAll(ctx context.Context) ([]models.WatchProgress, error) {
+	stored, err := r.next.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	history := make([]models.WatchProgress, 0, len(stored)+len(r.pending))
+	for _, p := range stored {
+		if _, ok := r.pending[progressKey(p.UserEmail, p.ImdbID)]; !ok {
+			history = append(history, p)
+		}
+	}
+	for _, p := range r.pending {
+		history = append(history, p)
+	}
+	return history, nil
+}
+
+// merge overlays the buffered progress of a user on stored history, keeping it most recent first
+func (r *BufferedWatchHistoryRepository) merge(email string, stored []models.WatchProgress, unfinishedOnly bool, limit int64) []models.WatchProgress {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := []models.WatchProgress{}
+	for _, p := range stored {
+		if _, ok := r.pending[progressKey(email, p.ImdbID)]; !ok {
+			history = append(history, p)
+		}
+	}
+	for _, p := range r.pending {
+		if p.UserEmail == email && !(unfinishedOnly && p.Finished) {
+			history = append(history, p)
+		}
+	}
+	sortByRecency(history)
+	if limit > 0 && int64(len(history)) > limit {
+		history = history[:limit]
+	}
+	return history
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"time"
+)
+
+type JobRepository interface {
+	// Insert assigns the job an ID when it has none
+	Insert(ctx context.Context, job *models.Job) error
+	FindByID(ctx context.Context, id string) (*models.Job, error)
+	// Claim atomically hands the oldest due job of one of the types to a worker: it is marked running with a lease
+	// until lockedUntil under a new Lease token and its attempts are incremented. Running jobs whose lease expired
+	// (their worker died or stalled) are claimed again. Returns ErrNotFound when there is nothing to do.
+	Claim(ctx context.Context, types []string, now, lockedUntil time.Time) (*models.Job, error)
+	// Extend renews the lease of a running job and returns it, so the worker sees cancellation requests. Extend,
+	// Retry and Finish return ErrNotFound unless lease is still the token of the job, i.e. it wasn't claimed again.
+	Extend(ctx context.Context, id, lease string, lockedUntil time.Time) (*models.Job, error)
+	// Retry puts a job back in the queue to run at runAt
+	Retry(ctx context.Context, id, lease string, attempts int, runAt time.Time, lastError string) error
+	// Finish records the final status of a job
+	Finish(ctx context.Context, id, lease string, status string, lastError string) error
+	// Cancel cancels a queued job right away and flags a running one for its worker to stop. dequeued tells whether
+	// this call took the job out of the queue, so it won't run at all.
+	Cancel(ctx context.Context, id string) (job *models.Job, dequeued bool, err error)
+}
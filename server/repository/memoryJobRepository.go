@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+type MemoryJobRepository struct {
+	mu   sync.RWMutex
+	jobs map[string]*models.Job
+}
+
+func NewMemoryJobRepository() *MemoryJobRepository {
+	return &MemoryJobRepository{jobs: map[string]*models.Job{}}
+}
+
+func cloneJob(j *models.Job) *models.Job {
+	c := *j
+	c.Payload = maps.Clone(j.Payload)
+	return &c
+}
+
+func (r *MemoryJobRepository) Insert(ctx context.Context, job *models.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if job.ID == "" {
+		job.ID = bson.NewObjectID().Hex()
+	}
+	r.jobs[job.ID] = cloneJob(job)
+	return nil
+}
+
+func (r *MemoryJobRepository) FindByID(ctx context.Context, id string) (*models.Job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return cloneJob(job), nil
+}
+
+func (r *MemoryJobRepository) Claim(ctx context.Context, types []string, now, lockedUntil time.Time) (*models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var next *models.Job
+	for _, job := range r.jobs {
+		if !slices.Contains(types, job.Type) {
+			continue
+		}
+		due := (job.Status == models.JobStatusQueued && !job.RunAt.After(now)) ||
+			(job.Status == models.JobStatusRunning && job.LockedUntil.Before(now))
+		if due && (next == nil || job.RunAt.Before(next.RunAt)) {
+			next = job
+		}
+	}
+	if next == nil {
+		return nil, ErrNotFound
+	}
+
+	next.Status = models.JobStatusRunning
+	next.Attempts++
+	next.LockedUntil = lockedUntil
+	next.Lease = bson.NewObjectID().Hex()
+	next.UpdatedAt = now
+	return cloneJob(next), nil
+}
+
+// leased returns the running job the caller holds the lease of, the lock must be held
+func (r *MemoryJobRepository) leased(id, lease string) (*models.Job, bool) {
+	job, ok := r.jobs[id]
+	if !ok || job.Status != models.JobStatusRunning || job.Lease != lease {
+		return nil, false
+	}
+	return job, true
+}
+
+func (r *MemoryJobRepository) Extend(ctx context.Context, id, lease string, lockedUntil time.Time) (*models.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.leased(id, lease)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	job.LockedUntil = lockedUntil
+	return cloneJob(job), nil
+}
+
+func (r *MemoryJobRepository) Retry(ctx context.Context, id, lease string, attempts int, runAt time.Time, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.leased(id, lease)
+	if !ok {
+		return ErrNotFound
+	}
+	job.Status = models.JobStatusQueued
+	job.Attempts = attempts
+	job.RunAt = runAt
+	job.LastError = lastError
+	job.LockedUntil = time.Time{}
+	job.Lease = ""
+	job.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *MemoryJobRepository) Finish(ctx context.Context, id, lease string, status string, lastError string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.leased(id, lease)
+	if !ok {
+		return ErrNotFound
+	}
+	now := time.Now()
+	job.Status = status
+	job.LastError = lastError
+	job.LockedUntil = time.Time{}
+	job.Lease = ""
+	job.UpdatedAt = now
+	job.FinishedAt = &now
+	return nil
+}
+
+func (r *MemoryJobRepository) Cancel(ctx context.Context, id string) (*models.Job, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, false, ErrNotFound
+	}
+	now := time.Now()
+	dequeued := false
+	switch job.Status {
+	case models.JobStatusQueued:
+		job.Status = models.JobStatusCancelled
+		job.FinishedAt = &now
+		job.UpdatedAt = now
+		dequeued = true
+	case models.JobStatusRunning:
+		job.CancelRequested = true
+		job.UpdatedAt = now
+	}
+	return cloneJob(job), dequeued, nil
+}
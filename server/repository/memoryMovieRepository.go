@@ -0,0 +1,323 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/search"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryMovieRepository keeps movies in insertion order. Values are copied on the way in and out so
+// callers can never mutate the stored documents.
+type MemoryMovieRepository struct {
+	mu     sync.RWMutex
+	movies []models.Movie
+}
+
+func NewMemoryMovieRepository() *MemoryMovieRepository {
+	return &MemoryMovieRepository{}
+}
+
+func (r *MemoryMovieRepository) FindAll(ctx context.Context) ([]models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	movies := make([]models.Movie, 0, len(r.movies))
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		movies = append(movies, cloneMovie(m))
+	}
+	return movies, nil
+}
+
+func (r *MemoryMovieRepository) AddMedia(ctx context.Context, imdbID string, file models.MediaFile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == imdbID {
+			if !slices.ContainsFunc(r.movies[i].Media, func(m models.MediaFile) bool { return m.Key == file.Key }) {
+				r.movies[i].Media = append(r.movies[i].Media, file)
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) SetHLS(ctx context.Context, imdbID string, hls models.HLSPackage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == imdbID {
+			hls.Renditions = append([]models.Rendition(nil), hls.Renditions...)
+			r.movies[i].HLS = &hls
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) SetCommunityScore(ctx context.Context, imdbID string, score models.CommunityScore) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == imdbID {
+			r.movies[i].Community = &score
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) List(ctx context.Context, opts MovieListOptions) ([]models.Movie, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var movies []models.Movie
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		if matchesMovieFilter(m, opts.Filter) {
+			movies = append(movies, cloneMovie(m))
+		}
+	}
+
+	// r.movies is in insertion order, so a stable sort keeps insertion order for ties
+	var less func(a, b models.Movie) bool
+	switch opts.SortBy {
+	case SortByRating:
+		less = func(a, b models.Movie) bool { return a.Rating < b.Rating }
+	case SortByTitle:
+		less = func(a, b models.Movie) bool { return strings.ToLower(a.Title) < strings.ToLower(b.Title) }
+	}
+	if less != nil {
+		sort.SliceStable(movies, func(i, j int) bool {
+			if opts.Desc {
+				return less(movies[j], movies[i])
+			}
+			return less(movies[i], movies[j])
+		})
+	} else if opts.Desc {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	total := int64(len(movies))
+	start := min(opts.skip(), total)
+	end := total
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, total)
+	}
+	return movies[start:end], total, nil
+}
+
+func matchesMovieFilter(m models.Movie, f MovieFilter) bool {
+	if len(f.GenreNames) > 0 && !hasGenre(m, func(g models.Genre) bool { return slices.Contains(f.GenreNames, g.GenreName) }) {
+		return false
+	}
+	if len(f.GenreIDs) > 0 && !hasGenre(m, func(g models.Genre) bool { return slices.Contains(f.GenreIDs, g.GenreID) }) {
+		return false
+	}
+	if f.MinRating > 0 && m.Rating < f.MinRating {
+		return false
+	}
+	if f.MaxRating > 0 && m.Rating > f.MaxRating {
+		return false
+	}
+	if f.TitlePrefix != "" && !strings.HasPrefix(strings.ToLower(m.Title), strings.ToLower(f.TitlePrefix)) {
+		return false
+	}
+	return true
+}
+
+func hasGenre(m models.Movie, match func(models.Genre) bool) bool {
+	for _, g := range m.Genres {
+		if match(g) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MemoryMovieRepository) FindByImdbID(ctx context.Context, imdbID string) (*models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		if m.ImdbID == imdbID {
+			movie := cloneMovie(m)
+			return &movie, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *MemoryMovieRepository) FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	movies := []models.Movie{}
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		if slices.Contains(imdbIDs, m.ImdbID) {
+			movies = append(movies, cloneMovie(m))
+		}
+	}
+	return movies, nil
+}
+
+func (r *MemoryMovieRepository) Insert(ctx context.Context, movie *models.Movie) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if slices.ContainsFunc(r.movies, func(m models.Movie) bool { return m.ImdbID == movie.ImdbID }) {
+		return ErrDuplicate
+	}
+
+	if movie.ID.IsZero() {
+		movie.ID = bson.NewObjectID()
+	}
+	r.movies = append(r.movies, cloneMovie(*movie))
+	return nil
+}
+
+func (r *MemoryMovieRepository) Update(ctx context.Context, movie *models.Movie, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == movie.ImdbID && r.movies[i].DeletedAt == nil {
+			m := &r.movies[i]
+			if version != AnyVersion && m.Version != version {
+				return ErrStaleMovie
+			}
+			m.Version++
+			m.Title = movie.Title
+			m.PosterPath = movie.PosterPath
+			m.YoutubeId = movie.YoutubeId
+			m.Genres = append([]models.Genre(nil), movie.Genres...)
+			m.AdminReview = movie.AdminReview
+			m.Rating = movie.Rating
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) SoftDelete(ctx context.Context, imdbID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == imdbID && r.movies[i].DeletedAt == nil {
+			r.movies[i].DeletedAt = &at
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) Restore(ctx context.Context, imdbID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.movies {
+		if r.movies[i].ImdbID == imdbID && r.movies[i].DeletedAt != nil {
+			r.movies[i].DeletedAt = nil
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *MemoryMovieRepository) SearchCandidates(ctx context.Context, terms []string, limit int64) ([]search.Candidate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	movies := []search.Candidate{}
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		if int64(len(movies)) >= limit {
+			break
+		}
+		text := []string{m.Title, m.AdminReview}
+		for _, g := range m.Genres {
+			text = append(text, g.GenreName)
+		}
+		words := search.Words(strings.Join(text, " "))
+		if slices.ContainsFunc(terms, func(term string) bool {
+			prefix := search.CandidatePrefix(term)
+			return slices.ContainsFunc(words, func(w string) bool { return strings.HasPrefix(w, prefix) })
+		}) {
+			movies = append(movies, search.Candidate{Movie: cloneMovie(m)})
+		}
+	}
+	return movies, nil
+}
+
+func (r *MemoryMovieRepository) FindRecommended(ctx context.Context, genreNames []string, limit int64) ([]models.Movie, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	wanted := make(map[string]bool, len(genreNames))
+	for _, name := range genreNames {
+		wanted[name] = true
+	}
+
+	var movies []models.Movie
+	for _, m := range r.movies {
+		if m.DeletedAt != nil {
+			continue
+		}
+		for _, g := range m.Genres {
+			if wanted[g.GenreName] {
+				movies = append(movies, cloneMovie(m))
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(movies, func(i, j int) bool { return movies[i].Rating > movies[j].Rating })
+	if limit > 0 && int64(len(movies)) > limit {
+		movies = movies[:limit]
+	}
+	return movies, nil
+}
+
+func cloneMovie(m models.Movie) models.Movie {
+	m.Genres = append([]models.Genre(nil), m.Genres...)
+	m.Media = append([]models.MediaFile(nil), m.Media...)
+	if m.HLS != nil {
+		hls := *m.HLS
+		hls.Renditions = append([]models.Rendition(nil), hls.Renditions...)
+		m.HLS = &hls
+	}
+	if m.Community != nil {
+		community := *m.Community
+		m.Community = &community
+	}
+	if m.DeletedAt != nil {
+		deletedAt := *m.DeletedAt
+		m.DeletedAt = &deletedAt
+	}
+	return m
+}
@@ -0,0 +1,131 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"sync"
+)
+
+type MemoryReviewRepository struct {
+	mu      sync.RWMutex
+	reviews []models.Review // oldest first
+}
+
+func NewMemoryReviewRepository() *MemoryReviewRepository {
+	return &MemoryReviewRepository{}
+}
+
+func (r *MemoryReviewRepository) index(imdbID, email string) int {
+	return slices.IndexFunc(r.reviews, func(rv models.Review) bool { return rv.ImdbID == imdbID && rv.UserEmail == email })
+}
+
+func (r *MemoryReviewRepository) Insert(ctx context.Context, review *models.Review) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.index(review.ImdbID, review.UserEmail) >= 0 {
+		return ErrDuplicate
+	}
+	if review.ID.IsZero() {
+		review.ID = bson.NewObjectID()
+	}
+	r.reviews = append(r.reviews, *review)
+	return nil
+}
+
+func (r *MemoryReviewRepository) Update(ctx context.Context, review *models.Review) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.index(review.ImdbID, review.UserEmail)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.reviews[i].Rating = review.Rating
+	r.reviews[i].Text = review.Text
+	r.reviews[i].Author = review.Author
+	r.reviews[i].UpdatedAt = review.UpdatedAt
+	*review = r.reviews[i]
+	return nil
+}
+
+func (r *MemoryReviewRepository) Delete(ctx context.Context, imdbID, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.index(imdbID, email)
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.reviews = slices.Delete(r.reviews, i, i+1)
+	return nil
+}
+
+func (r *MemoryReviewRepository) Find(ctx context.Context, imdbID, email string) (*models.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	i := r.index(imdbID, email)
+	if i < 0 {
+		return nil, ErrNotFound
+	}
+	review := r.reviews[i]
+	return &review, nil
+}
+
+func (r *MemoryReviewRepository) ListByMovie(ctx context.Context, imdbID string, page, limit int64) ([]models.Review, int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matches := []models.Review{}
+	for i := len(r.reviews) - 1; i >= 0; i-- {
+		if r.reviews[i].ImdbID == imdbID {
+			matches = append(matches, r.reviews[i])
+		}
+	}
+
+	total := int64(len(matches))
+	start := min((page-1)*limit, total)
+	end := min(start+limit, total)
+	return matches[start:end], total, nil
+}
+
+func (r *MemoryReviewRepository) FindByUser(ctx context.Context, email string) ([]models.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reviews := []models.Review{}
+	for i := len(r.reviews) - 1; i >= 0; i-- {
+		if r.reviews[i].UserEmail == email {
+			reviews = append(reviews, r.reviews[i])
+		}
+	}
+	return reviews, nil
+}
+
+func (r *MemoryReviewRepository) FindAll(ctx context.Context) ([]models.Review, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reviews := make([]models.Review, 0, len(r.reviews))
+	for _, rv := range r.reviews {
+		rv.Text = ""
+		reviews = append(reviews, rv)
+	}
+	return reviews, nil
+}
+
+func (r *MemoryReviewRepository) Summarize(ctx context.Context, imdbID string) (models.CommunityScore, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ratings []int
+	for _, rv := range r.reviews {
+		if rv.ImdbID == imdbID {
+			ratings = append(ratings, rv.Rating)
+		}
+	}
+	return summarize(ratings), nil
+}
@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sync"
+)
+
+type MemorySecurityEventRepository struct {
+	mu     sync.RWMutex
+	events []models.SecurityEvent
+}
+
+func NewMemorySecurityEventRepository() *MemorySecurityEventRepository {
+	return &MemorySecurityEventRepository{}
+}
+
+func (r *MemorySecurityEventRepository) Insert(ctx context.Context, event *models.SecurityEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.ID.IsZero() {
+		event.ID = bson.NewObjectID()
+	}
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *MemorySecurityEventRepository) FindByUser(ctx context.Context, email string) ([]models.SecurityEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []models.SecurityEvent{}
+	for i := len(r.events) - 1; i >= 0; i-- {
+		if r.events[i].UserEmail == email {
+			events = append(events, r.events[i])
+		}
+	}
+	return events, nil
+}
@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"sync"
+	"time"
+)
+
+type MemorySimilarityRepository struct {
+	mu           sync.RWMutex
+	similarities map[string]models.MovieSimilarity // by imdb_id
+}
+
+func NewMemorySimilarityRepository() *MemorySimilarityRepository {
+	return &MemorySimilarityRepository{similarities: map[string]models.MovieSimilarity{}}
+}
+
+func (r *MemorySimilarityRepository) ReplaceAll(ctx context.Context, similarities []models.MovieSimilarity, computedAt time.Time) error {
+	next := make(map[string]models.MovieSimilarity, len(similarities))
+	for _, s := range similarities {
+		s.Neighbors = append([]models.SimilarMovie(nil), s.Neighbors...)
+		s.ComputedAt = computedAt
+		next[s.ImdbID] = s
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.similarities = next
+	return nil
+}
+
+func (r *MemorySimilarityRepository) FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.MovieSimilarity, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	found := []models.MovieSimilarity{}
+	for _, id := range imdbIDs {
+		if s, ok := r.similarities[id]; ok {
+			s.Neighbors = append([]models.SimilarMovie(nil), s.Neighbors...)
+			found = append(found, s)
+		}
+	}
+	return found, nil
+}
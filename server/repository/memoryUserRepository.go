@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sync"
+)
+
+type MemoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]models.User // keyed by email
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[string]models.User)}
+}
+
+func (r *MemoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.users[email]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	user := cloneUser(u)
+	return &user, nil
+}
+
+func (r *MemoryUserRepository) CountByEmail(ctx context.Context, email string) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.users[email]; ok {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (r *MemoryUserRepository) Insert(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.Email]; ok {
+		return ErrDuplicate
+	}
+	if user.ID.IsZero() {
+		user.ID = bson.NewObjectID()
+	}
+	r.users[user.Email] = cloneUser(*user)
+	return nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if u, ok := r.users[user.Email]; ok && u.ID != user.ID {
+		return ErrDuplicate
+	}
+	for email, u := range r.users {
+		if u.ID == user.ID {
+			delete(r.users, email)
+			r.users[user.Email] = cloneUser(*user)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func cloneUser(u models.User) models.User {
+	u.FavouriteGenres = append([]models.Genre(nil), u.FavouriteGenres...)
+	return u
+}
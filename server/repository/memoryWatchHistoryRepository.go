@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"sort"
+	"sync"
+)
+
+type MemoryWatchHistoryRepository struct {
+	mu      sync.RWMutex
+	history map[string]models.WatchProgress // by progressKey
+}
+
+func NewMemoryWatchHistoryRepository() *MemoryWatchHistoryRepository {
+	return &MemoryWatchHistoryRepository{history: map[string]models.WatchProgress{}}
+}
+
+func progressKey(email, imdbID string) string {
+	return email + "\x00" + imdbID
+}
+
+func (r *MemoryWatchHistoryRepository) Save(ctx context.Context, progress models.WatchProgress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := progressKey(progress.UserEmail, progress.ImdbID)
+	progress.ID = r.history[key].ID
+	if progress.ID.IsZero() {
+		progress.ID = bson.NewObjectID()
+	}
+	r.history[key] = progress
+	return nil
+}
+
+func (r *MemoryWatchHistoryRepository) Find(ctx context.Context, email, imdbID string) (*models.WatchProgress, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	progress, ok := r.history[progressKey(email, imdbID)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &progress, nil
+}
+
+func (r *MemoryWatchHistoryRepository) FindByUser(ctx context.Context, email string) ([]models.WatchProgress, error) {
+	return r.find(email, false, 0), nil
+}
+
+func (r *MemoryWatchHistoryRepository) FindInProgress(ctx context.Context, email string, limit int64) ([]models.WatchProgress, error) {
+	return r.find(email, true, limit), nil
+}
+
+func (r *MemoryWatchHistoryRepository) FindAll(ctx context.Context) ([]models.WatchProgress, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := make([]models.WatchProgress, 0, len(r.history))
+	for _, p := range r.history {
+		history = append(history, p)
+	}
+	return history, nil
+}
+
+func (r *MemoryWatchHistoryRepository) find(email string, unfinishedOnly bool, limit int64) []models.WatchProgress {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history := []models.WatchProgress{}
+	for _, p := range r.history {
+		if p.UserEmail == email && !(unfinishedOnly && p.Finished) {
+			history = append(history, p)
+		}
+	}
+	sortByRecency(history)
+	if limit > 0 && int64(len(history)) > limit {
+		history = history[:limit]
+	}
+	return history
+}
+
+func sortByRecency(history []models.WatchProgress) {
+	sort.Slice(history, func(i, j int) bool { return history[i].UpdatedAt.After(history[j].UpdatedAt) })
+}
@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"slices"
+	"sync"
+	"time"
+)
+
+type MemoryWatchlistRepository struct {
+	mu    sync.RWMutex
+	lists map[string][]models.WatchlistItem // by user email, in order
+}
+
+func NewMemoryWatchlistRepository() *MemoryWatchlistRepository {
+	return &MemoryWatchlistRepository{lists: map[string][]models.WatchlistItem{}}
+}
+
+func (r *MemoryWatchlistRepository) Add(ctx context.Context, email, imdbID string, maxSize int) (*models.WatchlistItem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.lists[email]
+	if slices.ContainsFunc(list, func(it models.WatchlistItem) bool { return it.ImdbID == imdbID }) {
+		return nil, ErrDuplicate
+	}
+	if len(list) >= maxSize {
+		return nil, ErrWatchlistFull
+	}
+
+	item := models.WatchlistItem{ID: bson.NewObjectID(), UserEmail: email, ImdbID: imdbID, AddedAt: time.Now()}
+	if len(list) > 0 {
+		item.Position = list[len(list)-1].Position + 1
+	}
+	r.lists[email] = append(list, item)
+	return &item, nil
+}
+
+func (r *MemoryWatchlistRepository) Remove(ctx context.Context, email, imdbID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.lists[email]
+	i := slices.IndexFunc(list, func(it models.WatchlistItem) bool { return it.ImdbID == imdbID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.lists[email] = slices.Delete(list, i, i+1)
+	return nil
+}
+
+func (r *MemoryWatchlistRepository) Reorder(ctx context.Context, email string, imdbIDs []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	list := r.lists[email]
+	if !sameMovies(list, imdbIDs) {
+		return ErrWatchlistMismatch
+	}
+	reordered := make([]models.WatchlistItem, 0, len(list))
+	for pos, id := range imdbIDs {
+		i := slices.IndexFunc(list, func(it models.WatchlistItem) bool { return it.ImdbID == id })
+		item := list[i]
+		item.Position = pos
+		reordered = append(reordered, item)
+	}
+	r.lists[email] = reordered
+	return nil
+}
+
+func (r *MemoryWatchlistRepository) FindByUser(ctx context.Context, email string) ([]models.WatchlistItem, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]models.WatchlistItem{}, r.lists[email]...), nil
+}
@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+type MongoJobRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoJobRepository(collection *mongo.Collection) *MongoJobRepository {
+	return &MongoJobRepository{collection: collection}
+}
+
+// EnsureIndexes creates the index Claim looks for due jobs with
+func (r *MongoJobRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "status", Value: 1}, {Key: "type", Value: 1}, {Key: "run_at", Value: 1}},
+		Options: options.Index().SetName("job_queue"),
+	})
+	return err
+}
+
+func (r *MongoJobRepository) Insert(ctx context.Context, job *models.Job) error {
+	if job.ID == "" {
+		job.ID = bson.NewObjectID().Hex()
+	}
+	_, err := r.collection.InsertOne(ctx, job)
+	return err
+}
+
+func (r *MongoJobRepository) FindByID(ctx context.Context, id string) (*models.Job, error) {
+	var job models.Job
+	err := r.collection.FindOne(ctx, bson.D{{Key: "_id", Value: id}}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// findOneAndUpdate returns the updated job, or ErrNotFound when filter matched none
+func (r *MongoJobRepository) findOneAndUpdate(ctx context.Context, filter, update bson.D, opts *options.FindOneAndUpdateOptionsBuilder) (*models.Job, error) {
+	var job models.Job
+	err := r.collection.FindOneAndUpdate(ctx, filter, update, opts.SetReturnDocument(options.After)).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (r *MongoJobRepository) Claim(ctx context.Context, types []string, now, lockedUntil time.Time) (*models.Job, error) {
+	filter := bson.D{
+		{Key: "type", Value: bson.D{{Key: "$in", Value: types}}},
+		{Key: "$or", Value: bson.A{
+			bson.D{{Key: "status", Value: models.JobStatusQueued}, {Key: "run_at", Value: bson.D{{Key: "$lte", Value: now}}}},
+			bson.D{{Key: "status", Value: models.JobStatusRunning}, {Key: "locked_until", Value: bson.D{{Key: "$lt", Value: now}}}},
+		}},
+	}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.JobStatusRunning},
+			{Key: "locked_until", Value: lockedUntil},
+			{Key: "lease", Value: bson.NewObjectID().Hex()},
+			{Key: "updated_at", Value: now},
+		}},
+		{Key: "$inc", Value: bson.D{{Key: "attempts", Value: 1}}},
+	}
+	return r.findOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetSort(bson.D{{Key: "run_at", Value: 1}}))
+}
+
+func (r *MongoJobRepository) Extend(ctx context.Context, id, lease string, lockedUntil time.Time) (*models.Job, error) {
+	return r.findOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: id}, {Key: "status", Value: models.JobStatusRunning}, {Key: "lease", Value: lease}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "locked_until", Value: lockedUntil}}}},
+		options.FindOneAndUpdate(),
+	)
+}
+
+// updateLeased updates a running job the caller still holds the lease of, and gives the lease up
+func (r *MongoJobRepository) updateLeased(ctx context.Context, id, lease string, set bson.D) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "_id", Value: id}, {Key: "status", Value: models.JobStatusRunning}, {Key: "lease", Value: lease}},
+		bson.D{{Key: "$set", Value: set}, {Key: "$unset", Value: bson.D{{Key: "lease", Value: ""}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoJobRepository) Retry(ctx context.Context, id, lease string, attempts int, runAt time.Time, lastError string) error {
+	return r.updateLeased(ctx, id, lease, bson.D{
+		{Key: "status", Value: models.JobStatusQueued},
+		{Key: "attempts", Value: attempts},
+		{Key: "run_at", Value: runAt},
+		{Key: "last_error", Value: lastError},
+		{Key: "locked_until", Value: time.Time{}},
+		{Key: "updated_at", Value: time.Now()},
+	})
+}
+
+func (r *MongoJobRepository) Finish(ctx context.Context, id, lease string, status string, lastError string) error {
+	now := time.Now()
+	return r.updateLeased(ctx, id, lease, bson.D{
+		{Key: "status", Value: status},
+		{Key: "last_error", Value: lastError},
+		{Key: "locked_until", Value: time.Time{}},
+		{Key: "updated_at", Value: now},
+		{Key: "finished_at", Value: now},
+	})
+}
+
+func (r *MongoJobRepository) Cancel(ctx context.Context, id string) (*models.Job, bool, error) {
+	now := time.Now()
+	job, err := r.findOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: id}, {Key: "status", Value: models.JobStatusQueued}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "status", Value: models.JobStatusCancelled},
+			{Key: "updated_at", Value: now},
+			{Key: "finished_at", Value: now},
+		}}},
+		options.FindOneAndUpdate(),
+	)
+	if !errors.Is(err, ErrNotFound) {
+		return job, err == nil, err
+	}
+
+	job, err = r.findOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: id}, {Key: "status", Value: models.JobStatusRunning}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "cancel_requested", Value: true},
+			{Key: "updated_at", Value: now},
+		}}},
+		options.FindOneAndUpdate(),
+	)
+	if !errors.Is(err, ErrNotFound) {
+		return job, false, err
+	}
+	// already finished, or unknown
+	job, err = r.FindByID(ctx, id)
+	return job, false, err
+}
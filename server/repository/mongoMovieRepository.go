@@ -0,0 +1,378 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/search"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// genreField is the document field holding the embedded genres
+const genreField = "genre"
+
+// notDeleted matches the movies that aren't soft deleted
+var notDeleted = bson.E{Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: false}}}
+
+var movieSortFields = map[string]string{
+	SortByCreated: "_id",
+	SortByRating:  "rating",
+	SortByTitle:   "title",
+}
+
+type MongoMovieRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoMovieRepository(collection *mongo.Collection) *MongoMovieRepository {
+	return &MongoMovieRepository{collection: collection}
+}
+
+func (r *MongoMovieRepository) FindAll(ctx context.Context) ([]models.Movie, error) {
+	cursor, err := r.collection.Find(ctx, bson.D{notDeleted})
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []models.Movie
+	if err = cursor.All(ctx, &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+func (r *MongoMovieRepository) AddMedia(ctx context.Context, imdbID string, file models.MediaFile) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "imdb_id", Value: imdbID}, {Key: "media.key", Value: bson.D{{Key: "$ne", Value: file.Key}}}},
+		bson.D{{Key: "$push", Value: bson.D{{Key: "media", Value: file}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	// either the movie doesn't exist or the file is attached already
+	count, err := r.collection.CountDocuments(ctx, bson.D{{Key: "imdb_id", Value: imdbID}})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoMovieRepository) SetHLS(ctx context.Context, imdbID string, hls models.HLSPackage) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "imdb_id", Value: imdbID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "hls", Value: hls}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoMovieRepository) SetCommunityScore(ctx context.Context, imdbID string, score models.CommunityScore) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "imdb_id", Value: imdbID}},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "community_score", Value: score}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoMovieRepository) List(ctx context.Context, opts MovieListOptions) ([]models.Movie, int64, error) {
+	filter := mongoMovieFilter(opts.Filter)
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	field, ok := movieSortFields[opts.SortBy]
+	if !ok {
+		field = movieSortFields[SortByCreated]
+	}
+	order := 1
+	if opts.Desc {
+		order = -1
+	}
+	sort := bson.D{{Key: field, Value: order}}
+	if field != "_id" {
+		// ties are broken by insertion order so pages never overlap
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+	}
+
+	findOpts := options.Find().
+		SetSort(sort).
+		SetSkip(opts.skip()).
+		SetLimit(opts.Limit)
+
+	cursor, err := r.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var movies []models.Movie
+	if err = cursor.All(ctx, &movies); err != nil {
+		return nil, 0, err
+	}
+	return movies, total, nil
+}
+
+func mongoMovieFilter(f MovieFilter) bson.D {
+	filter := bson.D{notDeleted}
+	if len(f.GenreNames) > 0 {
+		filter = append(filter, bson.E{Key: genreField + ".genre_name", Value: bson.D{{Key: "$in", Value: f.GenreNames}}})
+	}
+	if len(f.GenreIDs) > 0 {
+		filter = append(filter, bson.E{Key: genreField + ".genre_id", Value: bson.D{{Key: "$in", Value: f.GenreIDs}}})
+	}
+	if f.MinRating > 0 || f.MaxRating > 0 {
+		rating := bson.D{}
+		if f.MinRating > 0 {
+			rating = append(rating, bson.E{Key: "$gte", Value: f.MinRating})
+		}
+		if f.MaxRating > 0 {
+			rating = append(rating, bson.E{Key: "$lte", Value: f.MaxRating})
+		}
+		filter = append(filter, bson.E{Key: "rating", Value: rating})
+	}
+	if f.TitlePrefix != "" {
+		filter = append(filter, bson.E{Key: "title", Value: bson.Regex{Pattern: "^" + regexp.QuoteMeta(f.TitlePrefix), Options: "i"}})
+	}
+	return filter
+}
+
+func (r *MongoMovieRepository) FindByImdbID(ctx context.Context, imdbID string) (*models.Movie, error) {
+	var movie models.Movie
+	err := r.collection.FindOne(ctx, bson.D{{Key: "imdb_id", Value: imdbID}, notDeleted}).Decode(&movie)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &movie, nil
+}
+
+func (r *MongoMovieRepository) FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.Movie, error) {
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "imdb_id", Value: bson.D{{Key: "$in", Value: imdbIDs}}}, notDeleted})
+	if err != nil {
+		return nil, err
+	}
+
+	movies := []models.Movie{}
+	if err = cursor.All(ctx, &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
+
+func (r *MongoMovieRepository) Insert(ctx context.Context, movie *models.Movie) error {
+	res, err := r.collection.InsertOne(ctx, movie)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(bson.ObjectID); ok {
+		movie.ID = id
+	}
+	return nil
+}
+
+func (r *MongoMovieRepository) Update(ctx context.Context, movie *models.Movie, version int) error {
+	filter := bson.D{{Key: "imdb_id", Value: movie.ImdbID}, notDeleted}
+	switch {
+	case version == 0:
+		// movies stored before versions were added have no version field
+		filter = append(filter, bson.E{Key: "version", Value: bson.D{{Key: "$in", Value: bson.A{0, nil}}}})
+	case version != AnyVersion:
+		filter = append(filter, bson.E{Key: "version", Value: version})
+	}
+	res, err := r.collection.UpdateOne(ctx, filter,
+		bson.D{
+			{Key: "$set", Value: bson.D{
+				{Key: "title", Value: movie.Title},
+				{Key: "poster_path", Value: movie.PosterPath},
+				{Key: "youtube_id", Value: movie.YoutubeId},
+				{Key: genreField, Value: movie.Genres},
+				{Key: "admin_review", Value: movie.AdminReview},
+				{Key: "rating", Value: movie.Rating},
+			}},
+			{Key: "$inc", Value: bson.D{{Key: "version", Value: 1}}},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+	if version == AnyVersion {
+		return ErrNotFound
+	}
+	// either the movie doesn't exist or it changed since it was read
+	count, err := r.collection.CountDocuments(ctx, bson.D{{Key: "imdb_id", Value: movie.ImdbID}, notDeleted})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	return ErrStaleMovie
+}
+
+func (r *MongoMovieRepository) SoftDelete(ctx context.Context, imdbID string, at time.Time) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "imdb_id", Value: imdbID}, notDeleted},
+		bson.D{{Key: "$set", Value: bson.D{{Key: "deleted_at", Value: at}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoMovieRepository) Restore(ctx context.Context, imdbID string) error {
+	res, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "imdb_id", Value: imdbID}, {Key: "deleted_at", Value: bson.D{{Key: "$exists", Value: true}}}},
+		bson.D{{Key: "$unset", Value: bson.D{{Key: "deleted_at", Value: ""}}}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// EnsureIndexes makes imdb_id unique (deleted movies included) and creates the text index used by
+// SearchCandidates, weighted like the search package ranks fields, and the genre index FindRecommended relies on
+func (r *MongoMovieRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "imdb_id", Value: 1}},
+			Options: options.Index().SetName("imdb_id").SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "title", Value: "text"},
+				{Key: "admin_review", Value: "text"},
+				{Key: genreField + ".genre_name", Value: "text"},
+			},
+			Options: options.Index().SetName("movie_text").SetWeights(bson.D{
+				{Key: "title", Value: search.TitleWeight},
+				{Key: "admin_review", Value: search.ReviewWeight},
+				{Key: genreField + ".genre_name", Value: search.GenreWeight},
+			}),
+		},
+		{
+			Keys:    bson.D{{Key: genreField + ".genre_name", Value: 1}, {Key: "rating", Value: -1}},
+			Options: options.Index().SetName("genre_rating"),
+		},
+	})
+	return err
+}
+
+func (r *MongoMovieRepository) SearchCandidates(ctx context.Context, terms []string, limit int64) ([]search.Candidate, error) {
+	if len(terms) == 0 {
+		return []search.Candidate{}, nil
+	}
+
+	// the text index finds stemmed matches anywhere in the catalogue ...
+	textOpts := options.Find().
+		SetProjection(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetSort(bson.D{{Key: "score", Value: bson.D{{Key: "$meta", Value: "textScore"}}}}).
+		SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "$text", Value: bson.D{{Key: "$search", Value: strings.Join(terms, " ")}}}, notDeleted}, textOpts)
+	if err != nil {
+		return nil, err
+	}
+	var scored []struct {
+		models.Movie `bson:",inline"`
+		Score        float64 `bson:"score"`
+	}
+	if err = cursor.All(ctx, &scored); err != nil {
+		return nil, err
+	}
+	candidates := make([]search.Candidate, 0, len(scored))
+	for _, m := range scored {
+		candidates = append(candidates, search.Candidate{Movie: m.Movie, TextScore: m.Score})
+	}
+
+	// ... while word prefixes catch partially typed and misspelled words the text index can't
+	var or bson.A
+	for _, term := range terms {
+		re := bson.Regex{Pattern: `\b` + regexp.QuoteMeta(search.CandidatePrefix(term)), Options: "i"}
+		for _, field := range []string{"title", "admin_review", genreField + ".genre_name"} {
+			or = append(or, bson.D{{Key: field, Value: re}})
+		}
+	}
+	cursor, err = r.collection.Find(ctx, bson.D{{Key: "$or", Value: or}, notDeleted}, options.Find().SetLimit(limit))
+	if err != nil {
+		return nil, err
+	}
+	var prefixed []models.Movie
+	if err = cursor.All(ctx, &prefixed); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[bson.ObjectID]bool, len(candidates))
+	for _, c := range candidates {
+		seen[c.Movie.ID] = true
+	}
+	for _, m := range prefixed {
+		if int64(len(candidates)) >= limit {
+			break
+		}
+		if !seen[m.ID] {
+			seen[m.ID] = true
+			candidates = append(candidates, search.Candidate{Movie: m})
+		}
+	}
+	return candidates, nil
+}
+
+func (r *MongoMovieRepository) FindRecommended(ctx context.Context, genreNames []string, limit int64) ([]models.Movie, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "rating", Value: -1}}).
+		SetLimit(limit)
+
+	filter := bson.D{{
+		Key:   genreField + ".genre_name",
+		Value: bson.D{{Key: "$in", Value: genreNames}},
+	}, notDeleted}
+
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var movies []models.Movie
+	if err = cursor.All(ctx, &movies); err != nil {
+		return nil, err
+	}
+	return movies, nil
+}
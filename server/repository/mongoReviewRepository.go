@@ -0,0 +1,172 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type MongoReviewRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoReviewRepository(collection *mongo.Collection) *MongoReviewRepository {
+	return &MongoReviewRepository{collection: collection}
+}
+
+// EnsureIndexes enforces one review per user and movie and backs the newest first listings
+func (r *MongoReviewRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "imdb_id", Value: 1}, {Key: "user_email", Value: 1}},
+			Options: options.Index().SetName("movie_user").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "imdb_id", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("movie_recent"),
+		},
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "created_at", Value: -1}},
+			Options: options.Index().SetName("user_recent"),
+		},
+	})
+	return err
+}
+
+func reviewFilter(imdbID, email string) bson.D {
+	return bson.D{{Key: "imdb_id", Value: imdbID}, {Key: "user_email", Value: email}}
+}
+
+func (r *MongoReviewRepository) Insert(ctx context.Context, review *models.Review) error {
+	res, err := r.collection.InsertOne(ctx, review)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(bson.ObjectID); ok {
+		review.ID = id
+	}
+	return nil
+}
+
+func (r *MongoReviewRepository) Update(ctx context.Context, review *models.Review) error {
+	err := r.collection.FindOneAndUpdate(ctx,
+		reviewFilter(review.ImdbID, review.UserEmail),
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "rating", Value: review.Rating},
+			{Key: "text", Value: review.Text},
+			{Key: "author", Value: review.Author},
+			{Key: "updated_at", Value: review.UpdatedAt},
+		}}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *MongoReviewRepository) Delete(ctx context.Context, imdbID, email string) error {
+	res, err := r.collection.DeleteOne(ctx, reviewFilter(imdbID, email))
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoReviewRepository) Find(ctx context.Context, imdbID, email string) (*models.Review, error) {
+	var review models.Review
+	err := r.collection.FindOne(ctx, reviewFilter(imdbID, email)).Decode(&review)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (r *MongoReviewRepository) ListByMovie(ctx context.Context, imdbID string, page, limit int64) ([]models.Review, int64, error) {
+	filter := bson.D{{Key: "imdb_id", Value: imdbID}}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reviews := []models.Review{}
+	if err = cursor.All(ctx, &reviews); err != nil {
+		return nil, 0, err
+	}
+	return reviews, total, nil
+}
+
+func (r *MongoReviewRepository) FindByUser(ctx context.Context, email string) ([]models.Review, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	return r.find(ctx, bson.D{{Key: "user_email", Value: email}}, opts)
+}
+
+func (r *MongoReviewRepository) FindAll(ctx context.Context) ([]models.Review, error) {
+	opts := options.Find().SetProjection(bson.D{{Key: "text", Value: 0}})
+	return r.find(ctx, bson.D{}, opts)
+}
+
+func (r *MongoReviewRepository) find(ctx context.Context, filter bson.D, opts *options.FindOptionsBuilder) ([]models.Review, error) {
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews := []models.Review{}
+	if err = cursor.All(ctx, &reviews); err != nil {
+		return nil, err
+	}
+	return reviews, nil
+}
+
+func (r *MongoReviewRepository) Summarize(ctx context.Context, imdbID string) (models.CommunityScore, error) {
+	// only the per rating counts come from the database, summarize derives the rest
+	cursor, err := r.collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "imdb_id", Value: imdbID}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$rating"},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return models.CommunityScore{}, err
+	}
+
+	var groups []struct {
+		Rating int `bson:"_id"`
+		Count  int `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return models.CommunityScore{}, err
+	}
+
+	var ratings []int
+	for _, g := range groups {
+		for i := 0; i < g.Count; i++ {
+			ratings = append(ratings, g.Rating)
+		}
+	}
+	return summarize(ratings), nil
+}
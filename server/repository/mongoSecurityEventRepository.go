@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type MongoSecurityEventRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoSecurityEventRepository(collection *mongo.Collection) *MongoSecurityEventRepository {
+	return &MongoSecurityEventRepository{collection: collection}
+}
+
+func (r *MongoSecurityEventRepository) Insert(ctx context.Context, event *models.SecurityEvent) error {
+	res, err := r.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+	if id, ok := res.InsertedID.(bson.ObjectID); ok {
+		event.ID = id
+	}
+	return nil
+}
+
+func (r *MongoSecurityEventRepository) FindByUser(ctx context.Context, email string) ([]models.SecurityEvent, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "user_email", Value: email}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []models.SecurityEvent
+	if err = cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
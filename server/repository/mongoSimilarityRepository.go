@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"time"
+)
+
+// similarityBatchSize bounds the number of upserts sent to the server at once
+const similarityBatchSize = 500
+
+type MongoSimilarityRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoSimilarityRepository(collection *mongo.Collection) *MongoSimilarityRepository {
+	return &MongoSimilarityRepository{collection: collection}
+}
+
+// EnsureIndexes keeps a single similarity document per movie
+func (r *MongoSimilarityRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "imdb_id", Value: 1}},
+		Options: options.Index().SetName("imdb_id").SetUnique(true),
+	})
+	return err
+}
+
+// ReplaceAll upserts the new similarities before deleting the stale ones, readers see either version of a movie
+// but never none
+func (r *MongoSimilarityRepository) ReplaceAll(ctx context.Context, similarities []models.MovieSimilarity, computedAt time.Time) error {
+	// dates are stored with millisecond precision, the fresh documents mustn't compare as older than computedAt
+	computedAt = computedAt.Truncate(time.Millisecond)
+	for start := 0; start < len(similarities); start += similarityBatchSize {
+		batch := similarities[start:min(start+similarityBatchSize, len(similarities))]
+		writes := make([]mongo.WriteModel, 0, len(batch))
+		for _, s := range batch {
+			s.ComputedAt = computedAt
+			writes = append(writes, mongo.NewReplaceOneModel().
+				SetFilter(bson.D{{Key: "imdb_id", Value: s.ImdbID}}).
+				SetReplacement(s).
+				SetUpsert(true))
+		}
+		if _, err := r.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+	}
+
+	_, err := r.collection.DeleteMany(ctx, bson.D{{Key: "computed_at", Value: bson.D{{Key: "$lt", Value: computedAt}}}})
+	return err
+}
+
+func (r *MongoSimilarityRepository) FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.MovieSimilarity, error) {
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "imdb_id", Value: bson.D{{Key: "$in", Value: imdbIDs}}}})
+	if err != nil {
+		return nil, err
+	}
+
+	similarities := []models.MovieSimilarity{}
+	if err = cursor.All(ctx, &similarities); err != nil {
+		return nil, err
+	}
+	return similarities, nil
+}
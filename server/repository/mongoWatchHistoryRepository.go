@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type MongoWatchHistoryRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoWatchHistoryRepository(collection *mongo.Collection) *MongoWatchHistoryRepository {
+	return &MongoWatchHistoryRepository{collection: collection}
+}
+
+// EnsureIndexes makes user and movie unique and backs the most recent first queries
+func (r *MongoWatchHistoryRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "imdb_id", Value: 1}},
+			Options: options.Index().SetName("user_movie").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "finished", Value: 1}, {Key: "updated_at", Value: -1}},
+			Options: options.Index().SetName("user_recent"),
+		},
+	})
+	return err
+}
+
+func (r *MongoWatchHistoryRepository) Save(ctx context.Context, progress models.WatchProgress) error {
+	_, err := r.collection.UpdateOne(ctx,
+		bson.D{{Key: "user_email", Value: progress.UserEmail}, {Key: "imdb_id", Value: progress.ImdbID}},
+		bson.D{{Key: "$set", Value: bson.D{
+			{Key: "position", Value: progress.Position},
+			{Key: "duration", Value: progress.Duration},
+			{Key: "finished", Value: progress.Finished},
+			{Key: "updated_at", Value: progress.UpdatedAt},
+		}}},
+		options.UpdateOne().SetUpsert(true),
+	)
+	return err
+}
+
+func (r *MongoWatchHistoryRepository) Find(ctx context.Context, email, imdbID string) (*models.WatchProgress, error) {
+	var progress models.WatchProgress
+	err := r.collection.FindOne(ctx, bson.D{{Key: "user_email", Value: email}, {Key: "imdb_id", Value: imdbID}}).Decode(&progress)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &progress, nil
+}
+
+func (r *MongoWatchHistoryRepository) FindByUser(ctx context.Context, email string) ([]models.WatchProgress, error) {
+	return r.find(ctx, bson.D{{Key: "user_email", Value: email}}, 0)
+}
+
+func (r *MongoWatchHistoryRepository) FindInProgress(ctx context.Context, email string, limit int64) ([]models.WatchProgress, error) {
+	return r.find(ctx, bson.D{{Key: "user_email", Value: email}, {Key: "finished", Value: false}}, limit)
+}
+
+func (r *MongoWatchHistoryRepository) FindAll(ctx context.Context) ([]models.WatchProgress, error) {
+	cursor, err := r.collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+
+	history := []models.WatchProgress{}
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (r *MongoWatchHistoryRepository) find(ctx context.Context, filter bson.D, limit int64) ([]models.WatchProgress, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	history := []models.WatchProgress{}
+	if err = cursor.All(ctx, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"slices"
+	"time"
+)
+
+type MongoWatchlistRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMongoWatchlistRepository(collection *mongo.Collection) *MongoWatchlistRepository {
+	return &MongoWatchlistRepository{collection: collection}
+}
+
+// EnsureIndexes creates the unique user and movie index duplicate protection relies on
+func (r *MongoWatchlistRepository) EnsureIndexes(ctx context.Context) error {
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_email", Value: 1}, {Key: "imdb_id", Value: 1}},
+		Options: options.Index().SetName("user_movie").SetUnique(true),
+	})
+	return err
+}
+
+func (r *MongoWatchlistRepository) Add(ctx context.Context, email, imdbID string, maxSize int) (*models.WatchlistItem, error) {
+	list, err := r.FindByUser(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	if slices.ContainsFunc(list, func(it models.WatchlistItem) bool { return it.ImdbID == imdbID }) {
+		return nil, ErrDuplicate
+	}
+	// concurrent adds may overshoot the limit by a few, the unique index still rules out duplicates
+	if len(list) >= maxSize {
+		return nil, ErrWatchlistFull
+	}
+
+	item := models.WatchlistItem{UserEmail: email, ImdbID: imdbID, AddedAt: time.Now()}
+	if len(list) > 0 {
+		item.Position = list[len(list)-1].Position + 1
+	}
+	res, err := r.collection.InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrDuplicate
+	}
+	if err != nil {
+		return nil, err
+	}
+	if id, ok := res.InsertedID.(bson.ObjectID); ok {
+		item.ID = id
+	}
+	return &item, nil
+}
+
+func (r *MongoWatchlistRepository) Remove(ctx context.Context, email, imdbID string) error {
+	res, err := r.collection.DeleteOne(ctx, bson.D{{Key: "user_email", Value: email}, {Key: "imdb_id", Value: imdbID}})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *MongoWatchlistRepository) Reorder(ctx context.Context, email string, imdbIDs []string) error {
+	list, err := r.FindByUser(ctx, email)
+	if err != nil {
+		return err
+	}
+	if !sameMovies(list, imdbIDs) {
+		return ErrWatchlistMismatch
+	}
+	if len(imdbIDs) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, 0, len(imdbIDs))
+	for pos, id := range imdbIDs {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "user_email", Value: email}, {Key: "imdb_id", Value: id}}).
+			SetUpdate(bson.D{{Key: "$set", Value: bson.D{{Key: "position", Value: pos}}}}))
+	}
+	_, err = r.collection.BulkWrite(ctx, writes)
+	return err
+}
+
+func (r *MongoWatchlistRepository) FindByUser(ctx context.Context, email string) ([]models.WatchlistItem, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "position", Value: 1}, {Key: "added_at", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.D{{Key: "user_email", Value: email}}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []models.WatchlistItem{}
+	if err = cursor.All(ctx, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,31 @@
+package repository
+
+const (
+	SortByCreated = "created"
+	SortByRating  = "rating"
+	SortByTitle   = "title"
+)
+
+// MovieFilter narrows a movie listing. Zero values are ignored, so an empty filter matches everything.
+type MovieFilter struct {
+	GenreNames  []string
+	GenreIDs    []int
+	MinRating   int
+	MaxRating   int
+	TitlePrefix string // case insensitive
+}
+
+type MovieListOptions struct {
+	Filter MovieFilter
+	SortBy string // one of the SortBy constants, defaults to SortByCreated
+	Desc   bool
+	Page   int64 // 1 based
+	Limit  int64
+}
+
+func (o MovieListOptions) skip() int64 {
+	if o.Page < 1 {
+		return 0
+	}
+	return (o.Page - 1) * o.Limit
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"github.com/ImranullahKhann/movie-streaming-app/server/search"
+	"time"
+)
+
+// AnyVersion makes MovieRepository.Update replace whatever version is stored
+const AnyVersion = -1
+
+// ErrStaleMovie means the movie was updated since the version an update was made from
+var ErrStaleMovie = errors.New("movie changed since it was read")
+
+// MovieRepository only returns movies that aren't deleted
+type MovieRepository interface {
+	FindAll(ctx context.Context) ([]models.Movie, error)
+	// List returns one page of the movies matching opts.Filter along with the total number of matches
+	List(ctx context.Context, opts MovieListOptions) ([]models.Movie, int64, error)
+	FindByImdbID(ctx context.Context, imdbID string) (*models.Movie, error)
+	// FindByImdbIDs returns the movies found among imdbIDs, in no particular order
+	FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.Movie, error)
+	// Insert returns ErrDuplicate when a movie, even a deleted one, has the same imdb_id
+	Insert(ctx context.Context, movie *models.Movie) error
+	// Update replaces the catalogue fields of the movie with the same imdb_id (title, poster, trailer, genres,
+	// admin review and rating) and bumps its version. Media, HLS renditions and the community score are left alone.
+	// It returns ErrStaleMovie when the stored version isn't version, unless version is AnyVersion.
+	Update(ctx context.Context, movie *models.Movie, version int) error
+	// SoftDelete hides a movie from then on, deleting it again returns ErrNotFound
+	SoftDelete(ctx context.Context, imdbID string, at time.Time) error
+	// Restore brings back a deleted movie, it returns ErrNotFound unless the movie is deleted
+	Restore(ctx context.Context, imdbID string) error
+	// AddMedia attaches a file to a movie, a file whose Key is attached already is left as it is so attaching can be
+	// retried
+	AddMedia(ctx context.Context, imdbID string, file models.MediaFile) error
+	SetHLS(ctx context.Context, imdbID string, hls models.HLSPackage) error
+	SetCommunityScore(ctx context.Context, imdbID string, score models.CommunityScore) error
+	// SearchCandidates returns up to limit movies that may match the search terms. Candidates contain a word
+	// starting with the search.CandidatePrefix of a term in their title, admin review or genre names, or are
+	// matched by a text index which then provides their TextScore. The final ranking is left to the search
+	// package.
+	SearchCandidates(ctx context.Context, terms []string, limit int64) ([]search.Candidate, error)
+	// FindRecommended returns up to limit movies having at least one of the given genre names, best rated first
+	FindRecommended(ctx context.Context, genreNames []string, limit int64) ([]models.Movie, error)
+}
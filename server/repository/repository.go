@@ -0,0 +1,11 @@
+package repository
+
+import "errors"
+
+// The repositories hide the storage engine from the controllers. Every interface in this package has a
+// MongoDB implementation used in production and an in-memory implementation for tests and local development.
+
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate is returned by writes that would break a uniqueness rule
+var ErrDuplicate = errors.New("duplicate")
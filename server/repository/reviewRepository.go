@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+)
+
+type ReviewRepository interface {
+	// Insert returns ErrDuplicate when the user reviewed the movie already
+	Insert(ctx context.Context, review *models.Review) error
+	// Update replaces the rating and text of the review of the same user and movie
+	Update(ctx context.Context, review *models.Review) error
+	Delete(ctx context.Context, imdbID, email string) error
+	Find(ctx context.Context, imdbID, email string) (*models.Review, error)
+	// ListByMovie returns one page of the reviews of a movie, newest first, along with their total number
+	ListByMovie(ctx context.Context, imdbID string, page, limit int64) ([]models.Review, int64, error)
+	// FindByUser returns every review of a user, newest first
+	FindByUser(ctx context.Context, email string) ([]models.Review, error)
+	// FindAll returns the ratings of every user in no particular order, the text of the reviews is left out
+	FindAll(ctx context.Context) ([]models.Review, error)
+	// Summarize computes the community score of a movie from its reviews
+	Summarize(ctx context.Context, imdbID string) (models.CommunityScore, error)
+}
+
+// summarize adds up ratings into a community score
+func summarize(ratings []int) models.CommunityScore {
+	var score models.CommunityScore
+	sum := 0
+	for _, r := range ratings {
+		if r < 1 || r > 10 {
+			continue
+		}
+		score.Distribution[r-1]++
+		score.Count++
+		sum += r
+	}
+	if score.Count > 0 {
+		score.Average = float64(sum) / float64(score.Count)
+	}
+	return score
+}
@@ -0,0 +1,12 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+)
+
+type SecurityEventRepository interface {
+	Insert(ctx context.Context, event *models.SecurityEvent) error
+	// FindByUser returns the events of a user, most recent first
+	FindByUser(ctx context.Context, email string) ([]models.SecurityEvent, error)
+}
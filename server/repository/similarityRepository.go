@@ -0,0 +1,14 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"time"
+)
+
+type SimilarityRepository interface {
+	// ReplaceAll stores the similarities of a batch run and drops those of movies computedAt no longer covers
+	ReplaceAll(ctx context.Context, similarities []models.MovieSimilarity, computedAt time.Time) error
+	// FindByImdbIDs returns the similarities found for imdbIDs, in no particular order
+	FindByImdbIDs(ctx context.Context, imdbIDs []string) ([]models.MovieSimilarity, error)
+}
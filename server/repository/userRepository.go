@@ -0,0 +1,15 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+)
+
+type UserRepository interface {
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	CountByEmail(ctx context.Context, email string) (int64, error)
+	// Insert and Update return ErrDuplicate when another user has the same email
+	Insert(ctx context.Context, user *models.User) error
+	// Update replaces the stored user having the same ID
+	Update(ctx context.Context, user *models.User) error
+}
@@ -0,0 +1,18 @@
+package repository
+
+import (
+	"context"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+)
+
+type WatchHistoryRepository interface {
+	// Save records the progress of a user in a movie, replacing the previous one
+	Save(ctx context.Context, progress models.WatchProgress) error
+	Find(ctx context.Context, email, imdbID string) (*models.WatchProgress, error)
+	// FindByUser returns everything a user played, most recent first
+	FindByUser(ctx context.Context, email string) ([]models.WatchProgress, error)
+	// FindInProgress returns up to limit unfinished titles of a user, most recent first
+	FindInProgress(ctx context.Context, email string, limit int64) ([]models.WatchProgress, error)
+	// FindAll returns the progress of every user, in no particular order
+	FindAll(ctx context.Context) ([]models.WatchProgress, error)
+}
@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"slices"
+)
+
+var (
+	ErrWatchlistFull = errors.New("watchlist is full")
+	// ErrWatchlistMismatch means a new order doesn't list exactly the movies of the watchlist
+	ErrWatchlistMismatch = errors.New("order doesn't match the watchlist")
+)
+
+type WatchlistRepository interface {
+	// Add appends a movie to the end of the user's watchlist. It returns ErrDuplicate when the movie is on it
+	// already and ErrWatchlistFull when the watchlist holds maxSize movies.
+	Add(ctx context.Context, email, imdbID string, maxSize int) (*models.WatchlistItem, error)
+	Remove(ctx context.Context, email, imdbID string) error
+	// Reorder gives the movies of the watchlist the order of imdbIDs, which must list each of them once
+	Reorder(ctx context.Context, email string, imdbIDs []string) error
+	// FindByUser returns the watchlist in order
+	FindByUser(ctx context.Context, email string) ([]models.WatchlistItem, error)
+}
+
+// sameMovies tells whether the watchlist holds exactly the movies of imdbIDs
+func sameMovies(items []models.WatchlistItem, imdbIDs []string) bool {
+	if len(items) != len(imdbIDs) {
+		return false
+	}
+	have := make([]string, 0, len(items))
+	for _, it := range items {
+		have = append(have, it.ImdbID)
+	}
+	want := slices.Clone(imdbIDs)
+	slices.Sort(have)
+	slices.Sort(want)
+	return slices.Equal(have, want)
+}
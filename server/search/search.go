@@ -0,0 +1,299 @@
+package search
+
+import (
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Field weights mirror the weights of the movies text index
+const (
+	TitleWeight  = 10
+	GenreWeight  = 5
+	ReviewWeight = 2
+
+	// share of the final score coming from text relevance, the rest comes from Movie.Rating
+	textShare = 0.8
+	// share of text relevance coming from the text index score when the backend provides one, the rest comes from
+	// the typo tolerant matching below
+	textIndexShare = 0.3
+
+	snippetRadius = 60
+)
+
+// PrefixLen is how many leading characters of a term must be typed correctly for a document to be
+// fetched as a candidate. Mistakes past that point are tolerated when ranking.
+const PrefixLen = 3
+
+// CandidatePrefix is the part of a term a document word has to start with to be considered at all
+func CandidatePrefix(term string) string {
+	runes := []rune(term)
+	return string(runes[:min(len(runes), PrefixLen)])
+}
+
+// Words returns the lower cased words of a text
+func Words(text string) []string {
+	var words []string
+	for _, s := range tokenize(text) {
+		words = append(words, s.word)
+	}
+	return words
+}
+
+// Candidate is a movie that may match a search. TextScore is the relevance the database text index gave it (its
+// textScore in MongoDB), 0 when it was found another way or the backend has no text index.
+type Candidate struct {
+	Movie     models.Movie
+	TextScore float64
+}
+
+type Result struct {
+	Movie      models.Movie      `json:"movie"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+type span struct {
+	start, end int // byte offsets into the original text
+	word       string
+}
+
+// Terms splits a query into unique lower cased words
+func Terms(query string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, s := range tokenize(query) {
+		if !seen[s.word] {
+			seen[s.word] = true
+			terms = append(terms, s.word)
+		}
+	}
+	return terms
+}
+
+// Rank scores every candidate against the query terms, drops the ones matching nothing and returns
+// the best limit results, highest score first. Text index scores, relative to the best of them, are blended in
+// when there are any: they catch stemmed forms ("ran" for "run") and repeated words the matching here doesn't.
+func Rank(candidates []Candidate, terms []string, limit int) []Result {
+	results := []Result{}
+	if len(terms) == 0 {
+		return results
+	}
+
+	maxTextScore := 0.0
+	for _, c := range candidates {
+		maxTextScore = max(maxTextScore, c.TextScore)
+	}
+
+	for _, c := range candidates {
+		m := c.Movie
+		title := matchField(m.Title, terms)
+		review := matchField(m.AdminReview, terms)
+
+		genreNames := make([]string, 0, len(m.Genres))
+		for _, g := range m.Genres {
+			genreNames = append(genreNames, g.GenreName)
+		}
+		genres := matchField(strings.Join(genreNames, " "), terms)
+
+		var text float64
+		matchedTerms := 0
+		for i := range terms {
+			termScore := TitleWeight*title.scores[i] + GenreWeight*genres.scores[i] + ReviewWeight*review.scores[i]
+			if termScore > 0 {
+				matchedTerms++
+			}
+			text += termScore
+		}
+		if matchedTerms == 0 && c.TextScore <= 0 {
+			continue
+		}
+		// normalise to 0..1 and favour documents matching more of the query
+		text = text / float64((TitleWeight+GenreWeight+ReviewWeight)*len(terms))
+		text *= float64(matchedTerms) / float64(len(terms))
+		if maxTextScore > 0 {
+			text = (1-textIndexShare)*text + textIndexShare*c.TextScore/maxTextScore
+		}
+
+		highlights := map[string]string{}
+		if len(title.spans) > 0 {
+			highlights["title"] = highlight(m.Title, title.spans)
+		}
+		if len(review.spans) > 0 {
+			highlights["admin_review"] = snippet(m.AdminReview, review.spans)
+		}
+
+		results = append(results, Result{
+			Movie:      m,
+			Score:      textShare*text + (1-textShare)*float64(m.Rating)/10,
+			Highlights: highlights,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+type fieldMatch struct {
+	scores []float64 // best score of each term within the field
+	spans  []span    // words of the field matched by any term
+}
+
+func matchField(text string, terms []string) fieldMatch {
+	fm := fieldMatch{scores: make([]float64, len(terms))}
+	for _, s := range tokenize(text) {
+		matched := false
+		for i, term := range terms {
+			if score := matchWord(term, s.word); score > 0 {
+				matched = true
+				fm.scores[i] = max(fm.scores[i], score)
+			}
+		}
+		if matched {
+			fm.spans = append(fm.spans, s)
+		}
+	}
+	return fm
+}
+
+// matchWord scores how well a query term matches a single word of a document: exact matches beat prefix
+// matches which beat matches needing typo correction.
+func matchWord(term, word string) float64 {
+	if term == word {
+		return 1
+	}
+	termLen := utf8.RuneCountInString(term)
+	if termLen >= 2 && strings.HasPrefix(word, term) {
+		return 0.8
+	}
+
+	allowed := 0
+	switch {
+	case termLen >= 8:
+		allowed = 2
+	case termLen >= 4:
+		allowed = 1
+	}
+	if allowed == 0 {
+		return 0
+	}
+
+	// compare against the whole word as well as its prefix of the same length so "incepton" still matches "inception"
+	wordRunes := []rune(word)
+	d := editDistance([]rune(term), wordRunes)
+	if len(wordRunes) > termLen {
+		d = min(d, editDistance([]rune(term), wordRunes[:termLen]))
+	}
+	if d <= allowed {
+		return 0.6 - 0.1*float64(d-1)
+	}
+	return 0
+}
+
+// editDistance is the optimal string alignment distance, a Levenshtein distance that also counts
+// swapping two adjacent characters as a single edit.
+func editDistance(a, b []rune) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+func tokenize(text string) []span {
+	var spans []span
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			spans = append(spans, span{start: start, end: i, word: strings.ToLower(text[start:i])})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start: start, end: len(text), word: strings.ToLower(text[start:])})
+	}
+	return spans
+}
+
+// highlight HTML escapes text and wraps the matched words in <mark> tags
+func highlight(text string, spans []span) string {
+	var b strings.Builder
+	last := 0
+	for _, s := range spans {
+		b.WriteString(html.EscapeString(text[last:s.start]))
+		b.WriteString("<mark>")
+		b.WriteString(html.EscapeString(text[s.start:s.end]))
+		b.WriteString("</mark>")
+		last = s.end
+	}
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
+
+// snippet highlights the part of a long text surrounding its first match
+func snippet(text string, spans []span) string {
+	from := max(spans[0].start-snippetRadius, 0)
+	to := min(spans[0].end+snippetRadius, len(text))
+	// don't cut words or multi byte characters in half
+	for from > 0 && !isBoundary(text, from) {
+		from--
+	}
+	for to < len(text) && !isBoundary(text, to) {
+		to++
+	}
+	if from > 0 {
+		// start after the space found
+		_, size := utf8.DecodeRuneInString(text[from:])
+		from += size
+	}
+
+	var inside []span
+	for _, s := range spans {
+		if s.start >= from && s.end <= to {
+			inside = append(inside, span{start: s.start - from, end: s.end - from, word: s.word})
+		}
+	}
+
+	out := highlight(text[from:to], inside)
+	if from > 0 {
+		out = "…" + out
+	}
+	if to < len(text) {
+		out += "…"
+	}
+	return out
+}
+
+func isBoundary(text string, i int) bool {
+	if !utf8.RuneStart(text[i]) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(text[i:])
+	return unicode.IsSpace(r)
+}
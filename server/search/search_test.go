@@ -0,0 +1,283 @@
+package search
+
+import (
+	"github.com/ImranullahKhann/movie-streaming-app/server/models"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"Inception", []string{"inception"}},
+		{"the Dark, the KNIGHT!", []string{"the", "dark", "knight"}},
+		{"Amélie 2001", []string{"amélie", "2001"}},
+	}
+	for _, tt := range tests {
+		if got := Terms(tt.query); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Terms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestCandidatePrefix(t *testing.T) {
+	tests := []struct {
+		term, want string
+	}{
+		{"in", "in"},
+		{"inc", "inc"},
+		{"inception", "inc"},
+		{"été", "été"},
+		{"étoile", "éto"},
+	}
+	for _, tt := range tests {
+		if got := CandidatePrefix(tt.term); got != tt.want {
+			t.Errorf("CandidatePrefix(%q) = %q, want %q", tt.term, got, tt.want)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"kitten", "kitten", 0},
+		{"kitten", "sitten", 1},
+		{"kitten", "sitting", 3},
+		{"incepton", "inception", 1},
+		{"inecption", "inception", 1}, // adjacent swap counts once
+		{"ca", "abc", 3},              // optimal string alignment, not full Damerau
+	}
+	for _, tt := range tests {
+		if got := editDistance([]rune(tt.a), []rune(tt.b)); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMatchWord(t *testing.T) {
+	tests := []struct {
+		term, word string
+		want       float64
+	}{
+		{"inception", "inception", 1},
+		{"incep", "inception", 0.8},
+		{"i", "inception", 0},          // too short to match as a prefix
+		{"incepton", "inception", 0.6}, // one typo
+		{"inecption", "inception", 0.6},
+		{"incpeiton", "inception", 0.5}, // two typos are allowed from 8 characters on
+		{"dakr", "dark", 0.6},
+		{"drak", "dark", 0.6},
+		{"dkar", "dark", 0}, // moving a letter takes two edits
+		{"cat", "car", 0},   // no typos below 4 characters
+		{"knight", "night", 0.6},
+		{"batman", "superman", 0},
+	}
+	for _, tt := range tests {
+		if got := matchWord(tt.term, tt.word); got != tt.want {
+			t.Errorf("matchWord(%q, %q) = %v, want %v", tt.term, tt.word, got, tt.want)
+		}
+	}
+}
+
+func movie(imdbID, title, review string, rating int, genres ...string) models.Movie {
+	m := models.Movie{ImdbID: imdbID, Title: title, AdminReview: review, Rating: rating}
+	for i, g := range genres {
+		m.Genres = append(m.Genres, models.Genre{GenreID: i + 1, GenreName: g})
+	}
+	return m
+}
+
+func candidates(movies ...models.Movie) []Candidate {
+	var cs []Candidate
+	for _, m := range movies {
+		cs = append(cs, Candidate{Movie: m})
+	}
+	return cs
+}
+
+func rankedIDs(results []Result) []string {
+	ids := []string{}
+	for _, r := range results {
+		ids = append(ids, r.Movie.ImdbID)
+	}
+	return ids
+}
+
+func TestRank(t *testing.T) {
+	tests := []struct {
+		name       string
+		candidates []Candidate
+		query      string
+		limit      int
+		want       []string
+	}{
+		{
+			name:  "no terms",
+			query: " ",
+			candidates: candidates(
+				movie("tt1", "Inception", "", 9),
+			),
+			want: []string{},
+		},
+		{
+			name:  "movies matching nothing are dropped",
+			query: "inception",
+			candidates: candidates(
+				movie("tt1", "Inception", "", 5),
+				movie("tt2", "Interstellar", "", 9),
+			),
+			want: []string{"tt1"},
+		},
+		{
+			name:  "exact beats prefix beats typo",
+			query: "dark",
+			candidates: candidates(
+				movie("typo", "The Dakr Side", "", 5),
+				movie("prefix", "Darkness Falls", "", 5),
+				movie("exact", "The Dark Knight", "", 5),
+			),
+			want: []string{"exact", "prefix", "typo"},
+		},
+		{
+			name:  "title outweighs genre outweighs review",
+			query: "crime",
+			candidates: candidates(
+				movie("review", "Heat", "a crime classic", 5),
+				movie("genre", "Goodfellas", "", 5, "Crime"),
+				movie("title", "Crime Story", "", 5),
+			),
+			want: []string{"title", "genre", "review"},
+		},
+		{
+			name:  "matching more terms wins",
+			query: "dark knight",
+			candidates: candidates(
+				movie("one", "Dark City", "", 9),
+				movie("both", "The Dark Knight", "", 5),
+			),
+			want: []string{"both", "one"},
+		},
+		{
+			name:  "rating breaks ties",
+			query: "star",
+			candidates: candidates(
+				movie("low", "Star Trek", "", 4),
+				movie("high", "Star Wars", "", 9),
+			),
+			want: []string{"high", "low"},
+		},
+		{
+			name:  "limit keeps the best",
+			query: "star",
+			limit: 1,
+			candidates: candidates(
+				movie("low", "Star Trek", "", 4),
+				movie("high", "Star Wars", "", 9),
+			),
+			want: []string{"high"},
+		},
+		{
+			name:  "text index score lifts a candidate",
+			query: "star",
+			candidates: []Candidate{
+				{Movie: movie("plain", "Star Trek", "", 5), TextScore: 2},
+				{Movie: movie("indexed", "Star Wars", "", 5), TextScore: 10},
+			},
+			want: []string{"indexed", "plain"},
+		},
+		{
+			name:  "text index matches are kept without a fuzzy match",
+			query: "ran",
+			candidates: []Candidate{
+				{Movie: movie("stemmed", "Run Lola Run", "", 5), TextScore: 4},
+				{Movie: movie("none", "Heat", "", 5)},
+			},
+			want: []string{"stemmed"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rankedIDs(Rank(tt.candidates, Terms(tt.query), tt.limit))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Rank(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankScores(t *testing.T) {
+	for _, c := range []Candidate{
+		{Movie: movie("tt1", "Incepton", "dream heist", 1, "Sci-Fi")},
+		{Movie: movie("tt2", "Inception", "", 10), TextScore: 50},
+	} {
+		for _, r := range Rank([]Candidate{c}, []string{"inception", "heist"}, 0) {
+			if r.Score < 0 || r.Score > 1 {
+				t.Errorf("score of %s is %v, want it within 0..1", r.Movie.ImdbID, r.Score)
+			}
+		}
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	longReview := strings.Repeat("filler words ", 10) + "a heist inside dreams " + strings.Repeat("more filler ", 10)
+	tests := []struct {
+		name  string
+		movie models.Movie
+		query string
+		want  map[string]string
+	}{
+		{
+			name:  "title words are marked",
+			movie: movie("tt1", "The Dark Knight", "", 5),
+			query: "dark knigth",
+			want:  map[string]string{"title": "The <mark>Dark</mark> <mark>Knight</mark>"},
+		},
+		{
+			name:  "text is HTML escaped",
+			movie: movie("tt1", "Tom & Jerry <3", "", 5),
+			query: "jerry",
+			want:  map[string]string{"title": "Tom &amp; <mark>Jerry</mark> &lt;3"},
+		},
+		{
+			name:  "genre matches aren't highlighted",
+			movie: movie("tt1", "Heat", "", 5, "Crime"),
+			query: "crime",
+			want:  map[string]string{},
+		},
+		{
+			name:  "long reviews are cut around the first match",
+			movie: movie("tt1", "Inception", longReview, 5),
+			query: "heist",
+			want: map[string]string{
+				"admin_review": "…words filler words filler words filler words filler words a <mark>heist</mark> inside dreams more filler more filler more filler more filler…",
+			},
+		},
+		{
+			name:  "short reviews are kept whole",
+			movie: movie("tt1", "Amélie", "Une fable légère et drôle", 5),
+			query: "drole légere",
+			want:  map[string]string{"admin_review": "Une fable <mark>légère</mark> et <mark>drôle</mark>"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := Rank(candidates(tt.movie), Terms(tt.query), 0)
+			if len(results) != 1 {
+				t.Fatalf("Rank(%q) returned %d results, want 1", tt.query, len(results))
+			}
+			if got := results[0].Highlights; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("highlights = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	userID string
+	exp    time.Time
+}
+
+// Memory is a process local TokenStore. Expired keys are dropped lazily when read and swept at most once a minute on writes.
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	byUser    map[string]map[string]struct{}
+	families  map[string]map[string]time.Time
+	sessions  map[string]Session
+	lastSweep time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries:  make(map[string]memoryEntry),
+		byUser:   make(map[string]map[string]struct{}),
+		families: make(map[string]map[string]time.Time),
+		sessions: make(map[string]Session),
+	}
+}
+
+func (m *Memory) SetJTI(ctx context.Context, key, userID string, exp time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > time.Minute {
+		m.sweep(now)
+	}
+
+	m.put(key, userID, exp)
+	return nil
+}
+
+func (m *Memory) DelJTI(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(key)
+	return nil
+}
+
+func (m *Memory) GetUserByJTI(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	if !time.Now().Before(e.exp) {
+		m.remove(key)
+		return "", ErrNotFound
+	}
+	return e.userID, nil
+}
+
+func (m *Memory) ConsumeJTI(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	m.remove(key)
+	if !time.Now().Before(e.exp) {
+		return "", ErrNotFound
+	}
+	return e.userID, nil
+}
+
+func (m *Memory) SwapJTI(ctx context.Context, key string, next map[string]time.Time) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	m.remove(key)
+	if !time.Now().Before(e.exp) {
+		return "", ErrNotFound
+	}
+	for k, exp := range next {
+		m.put(k, e.userID, exp)
+	}
+	return e.userID, nil
+}
+
+func (m *Memory) ListJTIsByUser(ctx context.Context, userID string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	keys := []string{}
+	for key := range m.byUser[userID] {
+		if !now.Before(m.entries[key].exp) {
+			m.remove(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m *Memory) AddToFamily(ctx context.Context, family, key string, exp time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.families[family] == nil {
+		m.families[family] = make(map[string]time.Time)
+	}
+	m.families[family][key] = exp
+	return nil
+}
+
+func (m *Memory) ListFamily(ctx context.Context, family string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	keys := []string{}
+	for key, exp := range m.families[family] {
+		if now.Before(exp) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (m *Memory) DelFamily(ctx context.Context, family string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.families, family)
+	return nil
+}
+
+func (m *Memory) SaveSession(ctx context.Context, s Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sessions[s.ID] = s
+	return nil
+}
+
+func (m *Memory) GetSession(ctx context.Context, id string) (*Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[id]
+	if !ok || !time.Now().Before(s.ExpiresAt) {
+		delete(m.sessions, id)
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (m *Memory) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sessions := []Session{}
+	for id, s := range m.sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(m.sessions, id)
+			continue
+		}
+		if s.UserID == userID {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *Memory) DelSession(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+	return nil
+}
+
+func (m *Memory) put(key, userID string, exp time.Time) {
+	if old, ok := m.entries[key]; ok {
+		m.unindex(old.userID, key)
+	}
+	m.entries[key] = memoryEntry{userID: userID, exp: exp}
+	if m.byUser[userID] == nil {
+		m.byUser[userID] = make(map[string]struct{})
+	}
+	m.byUser[userID][key] = struct{}{}
+}
+
+func (m *Memory) remove(key string) {
+	e, ok := m.entries[key]
+	if !ok {
+		return
+	}
+	delete(m.entries, key)
+	m.unindex(e.userID, key)
+}
+
+func (m *Memory) unindex(userID, key string) {
+	delete(m.byUser[userID], key)
+	if len(m.byUser[userID]) == 0 {
+		delete(m.byUser, userID)
+	}
+}
+
+func (m *Memory) sweep(now time.Time) {
+	for key, e := range m.entries {
+		if !now.Before(e.exp) {
+			m.remove(key)
+		}
+	}
+	for family, keys := range m.families {
+		for key, exp := range keys {
+			if !now.Before(exp) {
+				delete(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(m.families, family)
+		}
+	}
+	for id, s := range m.sessions {
+		if !now.Before(s.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+	m.lastSweep = now
+}
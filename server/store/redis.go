@@ -2,8 +2,11 @@ package store
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/redis/go-redis/v9"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -27,14 +30,197 @@ func NewRedis() *Redis {
 	return &Redis{Client: rdb}
 }
 
+// every key is also added to a sorted set per user, scored by its expiry, so we can list a user's tokens
+func userIndexKey(userID string) string {
+	return "user:" + userID + ":jtis"
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+func userSessionsKey(userID string) string {
+	return "user:" + userID + ":sessions"
+}
+
+func familyIndexKey(family string) string {
+	return "family:" + family + ":jtis"
+}
+
+// addToIndex queues adding key to the sorted set idx and dropping the entries that expired meanwhile
+func addToIndex(ctx context.Context, p redis.Pipeliner, idx, key string, exp time.Time) {
+	ttl := time.Until(exp)
+	p.ZAdd(ctx, idx, redis.Z{Score: float64(exp.Unix()), Member: key})
+	p.ZRemRangeByScore(ctx, idx, "-inf", "("+unixNow())
+	// the index lives as long as the longest lived token in it
+	p.ExpireNX(ctx, idx, ttl)
+	p.ExpireGT(ctx, idx, ttl)
+}
+
 func (r *Redis) SetJTI(ctx context.Context, key, userID string, exp time.Time) error {
-	return r.Client.Set(ctx, key, userID, time.Until(exp)).Err()
+	_, err := r.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, key, userID, time.Until(exp))
+		addToIndex(ctx, p, userIndexKey(userID), key, exp)
+		return nil
+	})
+	return err
 }
 
 func (r *Redis) DelJTI(ctx context.Context, key string) error {
-	return r.Client.Del(ctx, key).Err()
+	userID, err := r.Client.Get(ctx, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if err := r.Client.Del(ctx, key).Err(); err != nil {
+		return err
+	}
+	if userID != "" {
+		return r.Client.ZRem(ctx, userIndexKey(userID), key).Err()
+	}
+	return nil
 }
 
 func (r *Redis) GetUserByJTI(ctx context.Context, key string) (string, error) {
-	return r.Client.Get(ctx, key).Result()
+	userID, err := r.Client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	return userID, err
+}
+
+func (r *Redis) ConsumeJTI(ctx context.Context, key string) (string, error) {
+	userID, err := r.Client.GetDel(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, r.Client.ZRem(ctx, userIndexKey(userID), key).Err()
+}
+
+func (r *Redis) SwapJTI(ctx context.Context, key string, next map[string]time.Time) (string, error) {
+	var userID string
+	err := r.Client.Watch(ctx, func(tx *redis.Tx) error {
+		var err error
+		userID, err = tx.Get(ctx, key).Result()
+		if errors.Is(err, redis.Nil) {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(p redis.Pipeliner) error {
+			p.Del(ctx, key)
+			p.ZRem(ctx, userIndexKey(userID), key)
+			for k, exp := range next {
+				p.Set(ctx, k, userID, time.Until(exp))
+				addToIndex(ctx, p, userIndexKey(userID), k, exp)
+			}
+			return nil
+		})
+		return err
+	}, key)
+	// key changed between reading and swapping it: another request consumed it
+	if errors.Is(err, redis.TxFailedErr) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+func (r *Redis) ListJTIsByUser(ctx context.Context, userID string) ([]string, error) {
+	return r.Client.ZRangeByScore(ctx, userIndexKey(userID), &redis.ZRangeBy{Min: unixNow(), Max: "+inf"}).Result()
+}
+
+func (r *Redis) AddToFamily(ctx context.Context, family, key string, exp time.Time) error {
+	_, err := r.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		addToIndex(ctx, p, familyIndexKey(family), key, exp)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) ListFamily(ctx context.Context, family string) ([]string, error) {
+	return r.Client.ZRangeByScore(ctx, familyIndexKey(family), &redis.ZRangeBy{Min: unixNow(), Max: "+inf"}).Result()
+}
+
+func (r *Redis) DelFamily(ctx context.Context, family string) error {
+	return r.Client.Del(ctx, familyIndexKey(family)).Err()
+}
+
+func (r *Redis) SaveSession(ctx context.Context, s Session) error {
+	data, err := json.Marshal(redisSession{Session: s, UserID: s.UserID})
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Set(ctx, sessionKey(s.ID), data, time.Until(s.ExpiresAt))
+		addToIndex(ctx, p, userSessionsKey(s.UserID), s.ID, s.ExpiresAt)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) GetSession(ctx context.Context, id string) (*Session, error) {
+	data, err := r.Client.Get(ctx, sessionKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rs redisSession
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, err
+	}
+	rs.Session.UserID = rs.UserID
+	return &rs.Session, nil
+}
+
+func (r *Redis) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	ids, err := r.Client.ZRangeByScore(ctx, userSessionsKey(userID), &redis.ZRangeBy{Min: unixNow(), Max: "+inf"}).Result()
+	if err != nil {
+		return nil, err
+	}
+	sessions := []Session{}
+	for _, id := range ids {
+		s, err := r.GetSession(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *s)
+	}
+	return sessions, nil
+}
+
+func (r *Redis) DelSession(ctx context.Context, id string) error {
+	s, err := r.GetSession(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	_, err = r.Client.TxPipelined(ctx, func(p redis.Pipeliner) error {
+		p.Del(ctx, sessionKey(id))
+		p.ZRem(ctx, userSessionsKey(s.UserID), id)
+		return nil
+	})
+	return err
+}
+
+// redisSession stores the user ID that Session hides from its JSON form
+type redisSession struct {
+	Session
+	UserID string `json:"user_id"`
+}
+
+func unixNow() string {
+	return strconv.FormatInt(time.Now().Unix(), 10)
 }
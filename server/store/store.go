@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+var ErrNotFound = errors.New("key not found")
+
+// TokenStore keeps track of issued token IDs so they can be revoked before they expire.
+// Keys are namespaced by the caller (e.g. "access:<jti>") and map to the ID of the user owning them.
+type TokenStore interface {
+	SetJTI(ctx context.Context, key, userID string, exp time.Time) error
+	DelJTI(ctx context.Context, key string) error
+	GetUserByJTI(ctx context.Context, key string) (string, error)
+	// ConsumeJTI deletes key and returns its user in one step, so single use tokens can't be redeemed twice
+	ConsumeJTI(ctx context.Context, key string) (string, error)
+	// SwapJTI consumes key like ConsumeJTI and, in the same step, sets every key of next to its user until the
+	// given expiry. Whoever reads key's absence afterwards also sees next.
+	SwapJTI(ctx context.Context, key string, next map[string]time.Time) (string, error)
+	// ListJTIsByUser returns every key set for userID that has not expired or been deleted yet
+	ListJTIsByUser(ctx context.Context, userID string) ([]string, error)
+
+	// Families group the keys issued for one login session so they can be revoked together.
+	// AddToFamily only indexes key, it has to be set with SetJTI as well.
+	AddToFamily(ctx context.Context, family, key string, exp time.Time) error
+	ListFamily(ctx context.Context, family string) ([]string, error)
+	DelFamily(ctx context.Context, family string) error
+
+	// SaveSession creates or replaces a session, it is dropped once ExpiresAt passes
+	SaveSession(ctx context.Context, s Session) error
+	GetSession(ctx context.Context, id string) (*Session, error)
+	ListSessions(ctx context.Context, userID string) ([]Session, error)
+	DelSession(ctx context.Context, id string) error
+}
+
+// Session describes a login session, its ID is the family of the tokens issued for it
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"-"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// New picks the backend from TOKEN_STORE ("redis" or "memory"), defaulting to Redis.
+// The memory backend only suits single node deployments since revocations are not shared between processes.
+func New() TokenStore {
+	if os.Getenv("TOKEN_STORE") == "memory" {
+		return NewMemory()
+	}
+	return NewRedis()
+}
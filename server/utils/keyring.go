@@ -0,0 +1,338 @@
+package utils
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+
+	// a new key is published in the JWKS this long before it starts signing, so services caching the JWKS
+	// know it by the time tokens signed with it show up
+	keyPublishDelay = 10 * time.Minute
+	// a superseded key keeps verifying long enough for every access token it signed to expire
+	keyRetention = time.Hour
+	// tokens signed with an unknown key reload the keys from disk at most this often
+	keyReloadInterval = 10 * time.Second
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+type signingKey struct {
+	id        string
+	alg       string
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// KeyRing holds the keys access tokens are signed with. The newest published key signs, older ones only verify
+// until they are pruned. Keys are stored as PEM files in dir so every instance sharing the directory uses the
+// same keys and restarts don't log anyone out.
+type KeyRing struct {
+	mu          sync.RWMutex
+	dir         string
+	alg         string
+	rotateEvery time.Duration
+	keys        []*signingKey // oldest first
+	loadedAt    time.Time
+	misses      map[string]bool // IDs still unknown after the last reload
+}
+
+// NewKeyRingFromEnv configures the key ring with JWT_KEYS_DIR (default "keys"), JWT_SIGNING_ALG (EdDSA or RS256,
+// default EdDSA) and JWT_KEY_ROTATION, a duration like "720h" (the default)
+func NewKeyRingFromEnv() (*KeyRing, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		dir = "keys"
+	}
+	alg := os.Getenv("JWT_SIGNING_ALG")
+	if alg == "" {
+		alg = AlgEdDSA
+	}
+	rotateEvery := 30 * 24 * time.Hour
+	if v := os.Getenv("JWT_KEY_ROTATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWT_KEY_ROTATION: %w", err)
+		}
+		rotateEvery = d
+	}
+	return NewKeyRing(dir, alg, rotateEvery)
+}
+
+func NewKeyRing(dir, alg string, rotateEvery time.Duration) (*KeyRing, error) {
+	if alg != AlgEdDSA && alg != AlgRS256 {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	if rotateEvery <= keyPublishDelay {
+		return nil, fmt.Errorf("key rotation period must be longer than %s", keyPublishDelay)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	kr := &KeyRing{dir: dir, alg: alg, rotateEvery: rotateEvery}
+	if err := kr.Rotate(); err != nil {
+		return nil, err
+	}
+	return kr, nil
+}
+
+// Run rotates keys on schedule until ctx is done. It also picks up keys created by other instances.
+func (kr *KeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := kr.Rotate(); err != nil {
+				log.Printf("key rotation failed: %v", err)
+			}
+		}
+	}
+}
+
+// Rotate reloads the keys from disk, creates the next key when the newest one is due for rotation and prunes
+// keys that can't have signed a live token anymore
+func (kr *KeyRing) Rotate() error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+
+	if err := kr.load(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	// the next key is created early enough to be published for keyPublishDelay before it takes over
+	if len(kr.keys) == 0 || now.Sub(kr.keys[len(kr.keys)-1].createdAt) >= kr.rotateEvery-keyPublishDelay {
+		key, err := kr.generate(now)
+		if err != nil {
+			return err
+		}
+		kr.keys = append(kr.keys, key)
+	}
+
+	signer := kr.signerLocked()
+	kept := kr.keys[:0]
+	for i, key := range kr.keys {
+		// a key is superseded once a newer key signs
+		supersededAt := time.Time{}
+		if key != signer && i < len(kr.keys)-1 && !kr.keys[i+1].createdAt.After(signer.createdAt) {
+			supersededAt = kr.keys[i+1].createdAt.Add(keyPublishDelay)
+		}
+		if !supersededAt.IsZero() && now.Sub(supersededAt) > keyRetention {
+			if err := os.Remove(kr.keyPath(key.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, key)
+	}
+	kr.keys = kept
+	return nil
+}
+
+// signerLocked returns the newest key published for at least keyPublishDelay, or the oldest key when none is
+// (e.g. on the very first start)
+func (kr *KeyRing) signerLocked() *signingKey {
+	now := time.Now()
+	for i := len(kr.keys) - 1; i >= 0; i-- {
+		if now.Sub(kr.keys[i].createdAt) >= keyPublishDelay {
+			return kr.keys[i]
+		}
+	}
+	return kr.keys[0]
+}
+
+func (kr *KeyRing) signer() *signingKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.signerLocked()
+}
+
+// verificationKey returns the public key of kid. Unknown IDs trigger a reload since another instance may have
+// just rotated, but at most once every keyReloadInterval and not again for an ID the last reload didn't find, so
+// tokens with made up IDs can't keep the ring reading the disk. Keys are published keyPublishDelay before they
+// sign and Run reloads every minute, so valid tokens seldom need it.
+func (kr *KeyRing) verificationKey(kid string) (*signingKey, error) {
+	kr.mu.RLock()
+	key, reload := kr.lookupLocked(kid)
+	kr.mu.RUnlock()
+	if key != nil {
+		return key, nil
+	}
+	if !reload {
+		return nil, errUnknownKey
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	// another request may have reloaded in the meantime
+	if key, reload = kr.lookupLocked(kid); key != nil {
+		return key, nil
+	}
+	if !reload {
+		return nil, errUnknownKey
+	}
+	if err := kr.load(); err != nil {
+		return nil, err
+	}
+	if key, _ = kr.lookupLocked(kid); key != nil {
+		return key, nil
+	}
+	kr.misses[kid] = true
+	return nil, errUnknownKey
+}
+
+// lookupLocked finds the key of kid, or tells whether reloading the keys may find it
+func (kr *KeyRing) lookupLocked(kid string) (key *signingKey, reload bool) {
+	for _, key := range kr.keys {
+		if key.id == kid {
+			return key, false
+		}
+	}
+	return nil, !kr.misses[kid] && time.Since(kr.loadedAt) >= keyReloadInterval
+}
+
+func (kr *KeyRing) keyPath(id string) string {
+	return filepath.Join(kr.dir, id+".pem")
+}
+
+func (kr *KeyRing) generate(now time.Time) (*signingKey, error) {
+	var private crypto.Signer
+	var err error
+	switch kr.alg {
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	key := &signingKey{
+		id:        now.UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix),
+		alg:       kr.alg,
+		private:   private,
+		createdAt: now,
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	block := &pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{"Created": now.UTC().Format(time.RFC3339)},
+		Bytes:   der,
+	}
+	if err := os.WriteFile(kr.keyPath(key.id), pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// load replaces the keys with the ones found in the directory
+func (kr *KeyRing) load() error {
+	paths, err := filepath.Glob(filepath.Join(kr.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*signingKey, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return fmt.Errorf("%s: not a PEM file", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		createdAt, err := time.Parse(time.RFC3339, block.Headers["Created"])
+		if err != nil {
+			return fmt.Errorf("%s: invalid Created header: %w", path, err)
+		}
+
+		key := &signingKey{id: strings.TrimSuffix(filepath.Base(path), ".pem"), createdAt: createdAt}
+		switch k := parsed.(type) {
+		case ed25519.PrivateKey:
+			key.alg, key.private = AlgEdDSA, k
+		case *rsa.PrivateKey:
+			key.alg, key.private = AlgRS256, k
+		default:
+			return fmt.Errorf("%s: unsupported key type %T", path, parsed)
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.Before(keys[j].createdAt) })
+	kr.keys = keys
+	kr.loadedAt = time.Now()
+	kr.misses = map[string]bool{}
+	return nil
+}
+
+// JWK is a public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS lists the public keys of every key in the ring, including the ones not signing yet
+func (kr *KeyRing) JWKS() []JWK {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	enc := base64.RawURLEncoding
+	jwks := make([]JWK, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		jwk := JWK{Kid: key.id, Use: "sig", Alg: key.alg}
+		switch pub := key.private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv, jwk.X = "OKP", "Ed25519", enc.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = enc.EncodeToString(pub.N.Bytes())
+			jwk.E = enc.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		}
+		jwks = append(jwks, jwk)
+	}
+	return jwks
+}
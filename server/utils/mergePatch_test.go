@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// the examples of RFC 7396 appendix A whose patch is an object, plus a few of our own
+	tests := []struct {
+		doc, patch, want string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+		// nested objects merge member by member
+		{`{"title":"Heat","genre":[{"genre_id":1}],"hls":{"status":"ready","renditions":[]}}`,
+			`{"hls":{"status":null,"error":"x"},"title":"Heat (1995)"}`,
+			`{"title":"Heat (1995)","genre":[{"genre_id":1}],"hls":{"renditions":[],"error":"x"}}`},
+		{`{"a":"b"}`, `{}`, `{"a":"b"}`},
+		{`{"a":"b"}`, " {\"a\":\"c\"}\n\t", `{"a":"c"}`},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err != nil {
+			t.Errorf("MergePatch(%s, %s) failed: %v", tt.doc, tt.patch, err)
+			continue
+		}
+		var gotValue, wantValue any
+		if err := json.Unmarshal(got, &gotValue); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(gotValue, wantValue) {
+			t.Errorf("MergePatch(%s, %s) = %s, want %s", tt.doc, tt.patch, got, tt.want)
+		}
+	}
+}
+
+func TestMergePatchKeepsNumbers(t *testing.T) {
+	got, err := MergePatch([]byte(`{"id":9007199254740993,"ranking":1.50}`), []byte(`{"ranking":7}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"id":9007199254740993,"ranking":7}`; string(got) != want {
+		t.Errorf("MergePatch = %s, want %s", got, want)
+	}
+}
+
+func TestMergePatchRejects(t *testing.T) {
+	tests := []struct {
+		name, doc, patch string
+		want             error
+	}{
+		{"array patch", `{"a":"b"}`, `["c"]`, ErrPatchNotObject},
+		{"string patch", `{"a":"b"}`, `"c"`, ErrPatchNotObject},
+		{"null patch", `{"a":"b"}`, `null`, ErrPatchNotObject},
+		{"empty patch", `{"a":"b"}`, ``, nil},
+		{"invalid patch", `{"a":"b"}`, `{"a":`, nil},
+		{"garbage after the patch", `{"a":"b"}`, `{"title":"x"} garbage`, nil},
+		{"two patches", `{"a":"b"}`, `{"a":"c"}{"a":"d"}`, nil},
+		{"two documents", `{"a":"b"} {"a":"c"}`, `{"a":"d"}`, nil},
+	}
+	for _, tt := range tests {
+		got, err := MergePatch([]byte(tt.doc), []byte(tt.patch))
+		if err == nil {
+			t.Errorf("%s: MergePatch = %s, want an error", tt.name, got)
+			continue
+		}
+		if tt.want != nil && !errors.Is(err, tt.want) {
+			t.Errorf("%s: MergePatch error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Playback URLs carry their own proof of access so the stream and segment routes (or a CDN / static file server
+// in front of them) can check them without a database or token store lookup. The query parameters are:
+//
+//	exp  expiry as a unix timestamp
+//	u    email of the user the URL was minted for
+//	ip   client IP the URL is bound to, absent when unbound
+//	sig  base64url (no padding) HMAC-SHA256 with PLAYBACK_SECRET of "v1\n<imdb id>\n<u>\n<exp>\n<ip>"
+//
+// A signature covers every file of the movie, HLS playlists and segments included.
+
+var (
+	ErrPlaybackInvalid = errors.New("invalid playback signature")
+	ErrPlaybackExpired = errors.New("playback url expired")
+)
+
+// PlaybackGrant is what a signed playback URL allows
+type PlaybackGrant struct {
+	ImdbID    string
+	Email     string
+	ExpiresAt time.Time
+	IP        string
+}
+
+// PlaybackTTL is PLAYBACK_URL_TTL (a duration like "4h", the default). It must outlast the longest movie since
+// HLS players keep requesting segments until the end.
+func PlaybackTTL() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("PLAYBACK_URL_TTL")); err == nil && d > 0 {
+		return d
+	}
+	return 4 * time.Hour
+}
+
+// SignPlayback returns the query parameters to append to the movie's stream and HLS URLs
+func SignPlayback(g PlaybackGrant) (url.Values, error) {
+	secret := os.Getenv("PLAYBACK_SECRET")
+	if secret == "" {
+		return nil, errors.New("playback secret not configured")
+	}
+
+	exp := strconv.FormatInt(g.ExpiresAt.Unix(), 10)
+	q := url.Values{}
+	q.Set("exp", exp)
+	q.Set("u", g.Email)
+	if g.IP != "" {
+		q.Set("ip", g.IP)
+	}
+	q.Set("sig", playbackSignature(secret, g.ImdbID, g.Email, exp, g.IP))
+	return q, nil
+}
+
+// VerifyPlayback checks the query of a request for a file of imdbID made from clientIP. Like ParseRefresh it also
+// accepts PLAYBACK_SECRET_PREVIOUS so the secret can be rotated.
+func VerifyPlayback(imdbID string, q url.Values, clientIP string) (*PlaybackGrant, error) {
+	exp, email, ip, sig := q.Get("exp"), q.Get("u"), q.Get("ip"), q.Get("sig")
+	if exp == "" || email == "" || sig == "" {
+		return nil, ErrPlaybackInvalid
+	}
+	expUnix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return nil, ErrPlaybackInvalid
+	}
+
+	valid := false
+	for _, secret := range []string{os.Getenv("PLAYBACK_SECRET"), os.Getenv("PLAYBACK_SECRET_PREVIOUS")} {
+		if secret != "" && hmac.Equal([]byte(sig), []byte(playbackSignature(secret, imdbID, email, exp, ip))) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, ErrPlaybackInvalid
+	}
+
+	// expiry is only reported for genuine URLs, forged ones just get ErrPlaybackInvalid
+	expiresAt := time.Unix(expUnix, 0)
+	if time.Now().After(expiresAt) {
+		return nil, ErrPlaybackExpired
+	}
+	if ip != "" && ip != clientIP {
+		return nil, ErrPlaybackInvalid
+	}
+	return &PlaybackGrant{ImdbID: imdbID, Email: email, ExpiresAt: expiresAt, IP: ip}, nil
+}
+
+func playbackSignature(secret, imdbID, email, exp, ip string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{"v1", imdbID, email, exp, ip}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
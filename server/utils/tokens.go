@@ -9,6 +9,7 @@ import (
 	"github.com/google/uuid"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 )
 
@@ -20,36 +21,76 @@ type Tokens struct {
 	ExpAcc    time.Time
 	ExpRef    time.Time
 	UserEmail string
+	Role      string
+	Family    string
 }
 
-func IssueTokens(email string) (*Tokens, error) {
+// Claims are the JWT claims of both token kinds. Only access tokens carry the role, it is looked up again
+// when refreshing so role changes apply from the next refresh on.
+// Family identifies the login session: every pair issued by refreshing the session's tokens shares it.
+type Claims struct {
+	Role   string `json:"role,omitempty"`
+	Family string `json:"fam,omitempty"`
+	jwt.RegisteredClaims
+}
+
+var keyRing *KeyRing
+
+// UseKeyRing sets the key ring access tokens are signed and verified with
+func UseKeyRing(kr *KeyRing) {
+	keyRing = kr
+}
+
+// IssueTokens signs a new access/refresh pair. Pass an empty family when logging in to start a new one.
+func IssueTokens(email, role, family string) (*Tokens, error) {
+	if keyRing == nil {
+		return nil, errors.New("signing keys not configured")
+	}
+	if family == "" {
+		family = uuid.NewString()
+	}
 	now := time.Now().UTC()
 	t := &Tokens{
 		UserEmail: email,
+		Role:      role,
+		Family:    family,
 		JTIAcc:    uuid.NewString(),
 		JTIRef:    uuid.NewString(),
 		ExpAcc:    now.Add(15 * time.Minute),
 		ExpRef:    now.Add(7 * 25 * time.Hour),
 	}
 
-	// HS256 (HMAC with SHA-256) is a symmetric, keyed-hash algorithm used to sign JWT. It uses a single, shared secret for both generating and verifying signatures, making it fast and suitable for monolithic systems where the same entity creates and validates tokens
-	// HMAC (Hash-based Message Authentication Code) is a cryptographic mechanism that combines a hash function e.g sha-256 with a secret shared key to simultaneously verify both the data integrity and authenticity of the message. The sender generates a unique MAC (hash) using the message and key; if a receiver's recalculation matches the received MAC, it confirms the message is untampered (integrity) and originated from a trusted source (authenticity)
-	acc := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   email,
-		ID:        t.JTIAcc,
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(t.ExpAcc),
+	// Access tokens are signed with the private key of the key ring (EdDSA or RS256), the kid header tells verifiers
+	// which public key of the JWKS to check the signature with. Other services can verify them without sharing any secret
+	key := keyRing.signer()
+	acc := jwt.NewWithClaims(jwt.GetSigningMethod(key.alg), Claims{
+		Role:   role,
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   email,
+			ID:        t.JTIAcc,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(t.ExpAcc),
+		},
 	})
 
-	ref := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Subject:   email,
-		ID:        t.JTIRef,
-		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(t.ExpRef),
+	acc.Header["kid"] = key.id
+
+	// Refresh tokens are only ever verified by us, so they keep using HS256
+	// HS256 (HMAC with SHA-256) is a symmetric, keyed-hash algorithm used to sign JWT. It uses a single, shared secret for both generating and verifying signatures, making it fast and suitable for monolithic systems where the same entity creates and validates tokens
+	// HMAC (Hash-based Message Authentication Code) is a cryptographic mechanism that combines a hash function e.g sha-256 with a secret shared key to simultaneously verify both the data integrity and authenticity of the message. The sender generates a unique MAC (hash) using the message and key; if a receiver's recalculation matches the received MAC, it confirms the message is untampered (integrity) and originated from a trusted source (authenticity)
+	ref := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
+		Family: family,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   email,
+			ID:        t.JTIRef,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(t.ExpRef),
+		},
 	})
 
 	var err error
-	t.Access, err = acc.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	t.Access, err = acc.SignedString(key.private)
 	if err != nil {
 		return nil, err
 	}
@@ -61,13 +102,84 @@ func IssueTokens(email string) (*Tokens, error) {
 	return t, nil
 }
 
-func Persist(ctx context.Context, r *store.Redis, t *Tokens) error {
-	if err := r.SetJTI(ctx, "access:"+t.JTIAcc, t.UserEmail, t.ExpAcc); err != nil {
+// Persist records the JTIs of a freshly issued pair and creates or refreshes the session of its family
+func Persist(ctx context.Context, s store.TokenStore, t *Tokens, userAgent, ip string) error {
+	if err := s.SetJTI(ctx, "access:"+t.JTIAcc, t.UserEmail, t.ExpAcc); err != nil {
 		return err
 	}
-	if err := r.SetJTI(ctx, "refresh:"+t.JTIRef, t.UserEmail, t.ExpRef); err != nil {
+	if err := s.SetJTI(ctx, "refresh:"+t.JTIRef, t.UserEmail, t.ExpRef); err != nil {
 		return err
 	}
+	if err := s.AddToFamily(ctx, t.Family, "access:"+t.JTIAcc, t.ExpAcc); err != nil {
+		return err
+	}
+	if err := s.AddToFamily(ctx, t.Family, "refresh:"+t.JTIRef, t.ExpRef); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	session, err := s.GetSession(ctx, t.Family)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		session = &store.Session{ID: t.Family, UserID: t.UserEmail, CreatedAt: now}
+	}
+	session.Device = DeviceLabel(userAgent)
+	session.IP = ip
+	session.LastSeenAt = now
+	session.ExpiresAt = t.ExpRef
+	return s.SaveSession(ctx, *session)
+}
+
+// RevokeFamily deletes every key issued for a login session, ending it
+func RevokeFamily(ctx context.Context, s store.TokenStore, family string) error {
+	keys, err := s.ListFamily(ctx, family)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.DelJTI(ctx, key); err != nil {
+			return err
+		}
+	}
+	if err := s.DelFamily(ctx, family); err != nil {
+		return err
+	}
+	return s.DelSession(ctx, family)
+}
+
+// RevokeAllSessions signs the user out everywhere, including tokens issued before sessions were tracked
+func RevokeAllSessions(ctx context.Context, s store.TokenStore, userID string) error {
+	sessions, err := s.ListSessions(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if err := RevokeFamily(ctx, s, session.ID); err != nil {
+			return err
+		}
+	}
+	return RevokeUserKeys(ctx, s, userID, "access:", "refresh:", "rotated:")
+}
+
+// RevokeUserKeys deletes every key of the user starting with one of the prefixes, e.g. "access:" and "refresh:"
+// to sign them out everywhere
+func RevokeUserKeys(ctx context.Context, s store.TokenStore, userID string, prefixes ...string) error {
+	keys, err := s.ListJTIsByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				if err := s.DelJTI(ctx, key); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
 	return nil
 }
 
@@ -90,36 +202,56 @@ func ClearAuthCookies(c *gin.Context) {
 	c.SetCookie("refresh_token", "", -1, "/", "", true, true)
 }
 
-func ParseAccess(tokenStr string) (*jwt.RegisteredClaims, error) {
-	secret := os.Getenv("ACCESS_SECRET")
-	return parseWithSecret(tokenStr, secret)
-}
+func ParseAccess(tokenStr string) (*Claims, error) {
+	if keyRing == nil {
+		return nil, errors.New("signing keys not configured")
+	}
 
-func ParseRefresh(tokenStr string) (*jwt.RegisteredClaims, error) {
-	secret := os.Getenv("REFRESH_SECRET")
-	return parseWithSecret(tokenStr, secret)
+	return parseClaims(tokenStr, []string{AlgEdDSA, AlgRS256}, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, err := keyRing.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// Extra safety: the key decides the algorithm, not the token
+		if t.Method.Alg() != key.alg {
+			return nil, errors.New("unexpected signing method")
+		}
+		return key.private.Public(), nil
+	})
 }
 
-func parseWithSecret(tokenStr, secret string) (*jwt.RegisteredClaims, error) {
+// ParseRefresh accepts tokens signed with REFRESH_SECRET or REFRESH_SECRET_PREVIOUS, so the secret can be
+// rotated without logging everyone out: move the current secret to REFRESH_SECRET_PREVIOUS for a refresh token lifetime
+func ParseRefresh(tokenStr string) (*Claims, error) {
+	secret := os.Getenv("REFRESH_SECRET")
 	if secret == "" {
 		return nil, errors.New("jwt secret not configured")
 	}
+	keys := jwt.VerificationKeySet{Keys: []jwt.VerificationKey{[]byte(secret)}}
+	if previous := os.Getenv("REFRESH_SECRET_PREVIOUS"); previous != "" {
+		keys.Keys = append(keys.Keys, []byte(previous))
+	}
 
-	// telling the parser to only accept HS256
-	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
-
-	token, err := parser.ParseWithClaims(tokenStr, &jwt.RegisteredClaims{}, func(t *jwt.Token) (interface{}, error) {
+	return parseClaims(tokenStr, []string{jwt.SigningMethodHS256.Alg()}, func(t *jwt.Token) (interface{}, error) {
 		// Extra safety: ensure HMAC family
 		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("unexpected signing method")
 		}
-		return []byte(secret), nil
+		return keys, nil
 	})
+}
+
+func parseClaims(tokenStr string, methods []string, keyFunc jwt.Keyfunc) (*Claims, error) {
+	// telling the parser to only accept the expected algorithms
+	parser := jwt.NewParser(jwt.WithValidMethods(methods))
+
+	token, err := parser.ParseWithClaims(tokenStr, &Claims{}, keyFunc)
 	if err != nil {
 		return nil, err
 	}
 
-	claims, ok := token.Claims.(*jwt.RegisteredClaims)
+	claims, ok := token.Claims.(*Claims)
 	if !ok || !token.Valid {
 		return nil, errors.New("invalid token")
 	}